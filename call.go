@@ -3,7 +3,9 @@ package rpc
 import (
 	"context"
 	"github.com/obnahsgnaw/rpc/pkg/rpcclient"
+	"github.com/obnahsgnaw/rpc/pkg/rpcserver"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
 	"time"
 )
 
@@ -17,14 +19,70 @@ func (s *Server) ValCall(from, to, rqId, appid, uid string, cb func(context.Cont
 	return s.Manager().ValCall(s.app.Context(), from, to, rqId, appid, uid, cb)
 }
 
+func (s *Server) StreamCall(from, to, rqId, appid, uid string, cb func(context.Context, *grpc.ClientConn) error) error {
+	return s.Manager().StreamCall(s.app.Context(), from, to, rqId, appid, uid, cb)
+}
+
+// CtxCall call the module in a handler with the inbound header of ctx forwarded and rq_from set to the server id,
+// the fields can be overridden by opts
+func (s *Server) CtxCall(ctx context.Context, to string, cb func(context.Context, *grpc.ClientConn) error, opts ...rpcclient.CallOption) error {
+	return s.Manager().CtxCall(ctx, to, cb, opts...)
+}
+
+func (s *Server) CtxValCall(ctx context.Context, to string, cb func(context.Context, *grpc.ClientConn) (interface{}, error), opts ...rpcclient.CallOption) (interface{}, error) {
+	return s.Manager().CtxValCall(ctx, to, cb, opts...)
+}
+
+func (s *Server) CtxStreamCall(ctx context.Context, to string, cb func(context.Context, *grpc.ClientConn) error, opts ...rpcclient.CallOption) error {
+	return s.Manager().CtxStreamCall(ctx, to, cb, opts...)
+}
+
+func (s *Server) Broadcast(from, to, rqId, appid, uid string, cnf *rpcclient.BroadcastConfig, cb func(context.Context, *grpc.ClientConn) (interface{}, error)) ([]rpcclient.BroadcastResult, error) {
+	return s.Manager().Broadcast(s.app.Context(), from, to, rqId, appid, uid, cnf, cb)
+}
+
+func (s *Server) CtxBroadcast(ctx context.Context, to string, cnf *rpcclient.BroadcastConfig, cb func(context.Context, *grpc.ClientConn) (interface{}, error), opts ...rpcclient.CallOption) ([]rpcclient.BroadcastResult, error) {
+	return s.Manager().CtxBroadcast(ctx, to, cnf, cb, opts...)
+}
+
+// Invoke call the full method of the module in a handler like CtxValCall and return the typed response
+func Invoke[Req, Resp any, PReq interface {
+	*Req
+	proto.Message
+}, PResp interface {
+	*Resp
+	proto.Message
+}](ctx context.Context, s *Server, to, fullMethod string, req PReq, opts ...grpc.CallOption) (PResp, error) {
+	return rpcclient.Invoke[Req, Resp, PReq, PResp](ctx, s.Manager(), to, fullMethod, req, opts...)
+}
+
+// InvokeStub call the stub method built by newClient in a handler like CtxValCall and return the typed response
+func InvokeStub[C any, Req, Resp proto.Message](ctx context.Context, s *Server, to string, newClient func(grpc.ClientConnInterface) C, call func(C, context.Context, Req, ...grpc.CallOption) (Resp, error), req Req, opts ...grpc.CallOption) (Resp, error) {
+	return rpcclient.InvokeStub(ctx, s.Manager(), to, newClient, call, req, opts...)
+}
+
+// inboundHeader the header forwarded by the ctx calls
+func (s *Server) inboundHeader(ctx context.Context) rpcclient.Header {
+	head, _ := rpcserver.HeaderFromContext(ctx)
+	return rpcclient.Header{RqId: head.RqId, From: s.id, AppId: head.AppId, UserId: head.UserId}
+}
+
 func (s *Server) SetCallTtl(ttl time.Duration) {
 	s.Manager().SetCallTtl(ttl)
 }
 
+func (s *Server) SetStreamIdleTtl(ttl time.Duration) {
+	s.Manager().SetStreamIdleTtl(ttl)
+}
+
 func (s *Server) IsRpsError(err error) bool {
 	return s.Manager().IsRpsError(err)
 }
 
+func (s *Server) IsBreakerOpen(err error) bool {
+	return s.Manager().IsBreakerOpen(err)
+}
+
 func (s *Server) IsCustomError(err error) *rpcclient.CustomError {
 	return s.Manager().IsCustomError(err)
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	contextPackage   = protogen.GoImportPath("context")
+	grpcPackage      = protogen.GoImportPath("google.golang.org/grpc")
+	rpcclientPackage = protogen.GoImportPath("github.com/obnahsgnaw/rpc/pkg/rpcclient")
+	rpcserverPackage = protogen.GoImportPath("github.com/obnahsgnaw/rpc/pkg/rpcserver")
+)
+
+// generateFile generate the _rpcmodule.pb.go of the file, nothing for the file without services
+func generateFile(gen *protogen.Plugin, file *protogen.File) {
+	if len(file.Services) == 0 {
+		return
+	}
+	g := gen.NewGeneratedFile(file.GeneratedFilenamePrefix+"_rpcmodule.pb.go", file.GoImportPath)
+	g.P("// Code generated by protoc-gen-go-rpcmodule. DO NOT EDIT.")
+	g.P("// versions:")
+	g.P("// - protoc-gen-go-rpcmodule v", version)
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	for _, service := range file.Services {
+		generateService(g, service)
+	}
+}
+
+func generateService(g *protogen.GeneratedFile, service *protogen.Service) {
+	clientName := service.GoName + "ModuleClient"
+
+	g.P("// ", clientName, " the ", service.GoName, " client routed to a module by the rpcclient.Manager,")
+	g.P("// the header of the call is resolved from ctx and can be overridden by the call options")
+	if service.Desc.Options().(*descriptorpb.ServiceOptions).GetDeprecated() {
+		g.P("//")
+		g.P("// Deprecated: Do not use.")
+	}
+	g.P("type ", clientName, " struct {")
+	g.P("manager *", rpcclientPackage.Ident("Manager"))
+	g.P("module string")
+	g.P("}")
+	g.P()
+	g.P("func New", clientName, "(manager *", rpcclientPackage.Ident("Manager"), ", module string) *", clientName, " {")
+	g.P("return &", clientName, "{manager: manager, module: module}")
+	g.P("}")
+	g.P()
+
+	for _, method := range service.Methods {
+		generateMethod(g, service, clientName, method)
+	}
+
+	g.P("// New", service.GoName, "ServiceInfo return the ServiceInfo of ", service.GoName, " for rpc.Server.RegisterService")
+	g.P("func New", service.GoName, "ServiceInfo(impl ", service.GoName, "Server) ", rpcserverPackage.Ident("ServiceInfo"), " {")
+	g.P("return ", rpcserverPackage.Ident("ServiceInfo"), "{Desc: ", service.GoName, "_ServiceDesc, Impl: impl}")
+	g.P("}")
+	g.P()
+}
+
+func generateMethod(g *protogen.GeneratedFile, service *protogen.Service, clientName string, method *protogen.Method) {
+	fullMethod := "/" + string(service.Desc.FullName()) + "/" + string(method.Desc.Name())
+	ctx := g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	callOption := g.QualifiedGoIdent(rpcclientPackage.Ident("CallOption"))
+	in := g.QualifiedGoIdent(method.Input.GoIdent)
+	out := g.QualifiedGoIdent(method.Output.GoIdent)
+	streamClient := service.GoName + "_" + method.GoName + "Client"
+
+	if method.Comments.Leading != "" {
+		g.P(method.Comments.Leading, "//")
+	}
+	switch {
+	case !method.Desc.IsStreamingClient() && !method.Desc.IsStreamingServer():
+		g.P("// ", method.GoName, " call ", fullMethod, " of the module")
+		g.P("func (c *", clientName, ") ", method.GoName, "(ctx ", ctx, ", in *", in, ", opts ...", callOption, ") (*", out, ", error) {")
+		g.P("return ", rpcclientPackage.Ident("Invoke"), "[", in, ", ", out, "](", rpcclientPackage.Ident("WithCallOptions"), "(ctx, opts...), c.manager, c.module, \"", fullMethod, "\", in)")
+		g.P("}")
+	case !method.Desc.IsStreamingClient():
+		g.P("// ", method.GoName, " call ", fullMethod, " of the module, the stream is received in cb,")
+		g.P("// it is canceled after the stream idle ttl without any message")
+		g.P("func (c *", clientName, ") ", method.GoName, "(ctx ", ctx, ", in *", in, ", cb func(", streamClient, ") error, opts ...", callOption, ") error {")
+		g.P("return c.manager.CtxStreamCall(ctx, c.module, func(ctx ", ctx, ", cc *", grpcPackage.Ident("ClientConn"), ") error {")
+		g.P("stream, err := New", service.GoName, "Client(cc).", method.GoName, "(ctx, in)")
+		g.P("if err != nil {")
+		g.P("return err")
+		g.P("}")
+		g.P("return cb(stream)")
+		g.P("}, opts...)")
+		g.P("}")
+	default:
+		g.P("// ", method.GoName, " call ", fullMethod, " of the module, the stream is sent and received in cb,")
+		g.P("// it is canceled after the stream idle ttl without any message")
+		g.P("func (c *", clientName, ") ", method.GoName, "(ctx ", ctx, ", cb func(", streamClient, ") error, opts ...", callOption, ") error {")
+		g.P("return c.manager.CtxStreamCall(ctx, c.module, func(ctx ", ctx, ", cc *", grpcPackage.Ident("ClientConn"), ") error {")
+		g.P("stream, err := New", service.GoName, "Client(cc).", method.GoName, "(ctx)")
+		g.P("if err != nil {")
+		g.P("return err")
+		g.P("}")
+		g.P("return cb(stream)")
+		g.P("}, opts...)")
+		g.P("}")
+	}
+	g.P()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// userProto the descriptor of testdata/user.proto, built by hand so the test not need protoc
+func userProto() *descriptorpb.FileDescriptorProto {
+	message := func(name string) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: []*descriptorpb.FieldDescriptorProto{{
+			Name:     proto.String("id"),
+			JsonName: proto.String("id"),
+			Number:   proto.Int32(1),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+		}}}
+	}
+	method := func(name string, clientStreaming, serverStreaming bool) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{Name: proto.String(name), InputType: proto.String(".user.GetReq"), OutputType: proto.String(".user.GetResp"),
+			ClientStreaming: proto.Bool(clientStreaming), ServerStreaming: proto.Bool(serverStreaming)}
+	}
+	return &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("user.proto"),
+		Package:     proto.String("user"),
+		Syntax:      proto.String("proto3"),
+		Options:     &descriptorpb.FileOptions{GoPackage: proto.String("example.com/user;user")},
+		MessageType: []*descriptorpb.DescriptorProto{message("GetReq"), message("GetResp")},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name:   proto.String("User"),
+			Method: []*descriptorpb.MethodDescriptorProto{method("Get", false, false), method("Watch", false, true), method("Chat", true, true)},
+		}},
+		SourceCodeInfo: &descriptorpb.SourceCodeInfo{Location: []*descriptorpb.SourceCodeInfo_Location{{
+			// the comment of the Get method
+			Path:            []int32{6, 0, 2, 0},
+			Span:            []int32{16, 2, 37},
+			LeadingComments: proto.String(" Get the user by id\n"),
+		}}},
+	}
+}
+
+func TestGenerateFile(t *testing.T) {
+	gen, err := protogen.Options{}.New(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"user.proto"},
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{userProto()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range gen.Files {
+		if f.Generate {
+			generateFile(gen, f)
+		}
+	}
+	resp := gen.Response()
+	if resp.Error != nil {
+		t.Fatal(resp.GetError())
+	}
+	if len(resp.File) != 1 || resp.File[0].GetName() != "example.com/user/user_rpcmodule.pb.go" {
+		t.Fatalf("want the user_rpcmodule.pb.go, got %v", resp.File)
+	}
+
+	golden := filepath.Join("testdata", "user_rpcmodule.pb.go.golden")
+	if *update {
+		if err = os.WriteFile(golden, []byte(resp.File[0].GetContent()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.File[0].GetContent(); got != string(want) {
+		t.Fatalf("the generated code not match %s, run go test -update to accept it:\n%s", golden, got)
+	}
+}
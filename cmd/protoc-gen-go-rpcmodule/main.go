@@ -0,0 +1,34 @@
+// protoc-gen-go-rpcmodule generate for each service a client bound to a module name and routed by the rpcclient.Manager,
+// and a ServiceInfo constructor for rpc.Server.RegisterService. It is used along with protoc-gen-go and protoc-gen-go-grpc:
+//
+//	protoc --go_out=. --go-grpc_out=. --go-rpcmodule_out=. user.proto
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+const version = "0.1.0"
+
+func main() {
+	showVersion := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("protoc-gen-go-rpcmodule %v\n", version)
+		return
+	}
+
+	protogen.Options{ParamFunc: flag.CommandLine.Set}.Run(func(gen *protogen.Plugin) error {
+		gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+		for _, f := range gen.Files {
+			if f.Generate {
+				generateFile(gen, f)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,94 @@
+package rpcutil
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// FileLoader load the yaml or json(by the .json ext) config file, and reload it when modified,
+// the modification is checked at most once per interval by Refresh
+type FileLoader struct {
+	mu        sync.Mutex
+	name      string
+	file      string
+	interval  time.Duration
+	checkedAt time.Time
+	modTime   time.Time
+	apply     func(decode func(v interface{}) error) error
+	logger    *zap.Logger
+}
+
+// NewFileLoader load the file by apply, which decode the file into a new config and take it if valid,
+// name the prefix of the errors and logs, interval default 10s
+func NewFileLoader(name, file string, interval time.Duration, apply func(decode func(v interface{}) error) error, l *zap.Logger) (*FileLoader, error) {
+	f := &FileLoader{name: name, file: file, interval: interval, apply: apply, logger: l}
+	if f.interval <= 0 {
+		f.interval = 10 * time.Second
+	}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Refresh reload the file if modified, the current config is kept if the new file is invalid,
+// call it without the lock of the config held, apply takes it, the call returns at once when another one is checking
+func (f *FileLoader) Refresh() {
+	if !f.mu.TryLock() {
+		return
+	}
+	defer f.mu.Unlock()
+	if time.Since(f.checkedAt) < f.interval {
+		return
+	}
+	f.checkedAt = time.Now()
+	info, err := os.Stat(f.file)
+	if err != nil || !info.ModTime().After(f.modTime) {
+		return
+	}
+	if err = f.load(); err != nil {
+		// not retry the broken file until modified again
+		f.modTime = info.ModTime()
+		if f.logger != nil {
+			f.logger.Error(f.name + " reload failed, " + err.Error())
+		}
+	}
+}
+
+func (f *FileLoader) load() error {
+	info, err := os.Stat(f.file)
+	if err != nil {
+		return errors.New(f.name + ": " + err.Error())
+	}
+	b, err := os.ReadFile(f.file)
+	if err != nil {
+		return errors.New(f.name + ": " + err.Error())
+	}
+	err = f.apply(func(v interface{}) error {
+		var err error
+		if filepath.Ext(f.file) == ".json" {
+			err = json.Unmarshal(b, v)
+		} else {
+			err = yaml.Unmarshal(b, v)
+		}
+		if err != nil {
+			return errors.New(f.name + ": parse " + f.file + " failed, " + err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	f.modTime = info.ModTime()
+	if f.logger != nil {
+		f.logger.Info(f.name+" loaded", zap.String("file", f.file))
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package rpcutil
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc/status"
+)
+
+// the ErrorInfo detail of the status errors shared by the rpcserver and rpcclient
+const (
+	// ErrorDomain the domain of the ErrorInfo detail carrying the code and status
+	ErrorDomain = "rpc"
+	// ThrottleReason the reason of the throttled calls, the retry_after metadata is the wait in milliseconds
+	ThrottleReason = "THROTTLED"
+)
+
+// SplitMethod split the full method /package.service/method
+func SplitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
+
+// FinishOnDone call done with the ctx error as status when ctx is done, so the client stream abandoned before the end
+// is finished by the ctx, done should only take the first call
+func FinishOnDone(ctx context.Context, done func(err error)) {
+	go func() {
+		<-ctx.Done()
+		done(status.FromContextError(ctx.Err()).Err())
+	}()
+}
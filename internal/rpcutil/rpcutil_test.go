@@ -0,0 +1,39 @@
+package rpcutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFinishOnDone(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel1 := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel1()
+	for want, ctx := range map[codes.Code]context.Context{codes.Canceled: canceled, codes.DeadlineExceeded: expired} {
+		finished := make(chan error, 1)
+		FinishOnDone(ctx, func(err error) {
+			finished <- err
+		})
+		select {
+		case err := <-finished:
+			if status.Code(err) != want {
+				t.Fatalf("want the %s status, got %v", want, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("the done not called for %s", want)
+		}
+	}
+}
+
+func TestSplitMethod(t *testing.T) {
+	for fullMethod, want := range map[string][2]string{"/user.User/Get": {"user.User", "Get"}, "Get": {"unknown", "Get"}} {
+		if service, method := SplitMethod(fullMethod); service != want[0] || method != want[1] {
+			t.Fatalf("%s: want %v, got %s and %s", fullMethod, want, service, method)
+		}
+	}
+}
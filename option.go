@@ -1,8 +1,18 @@
 package rpc
 
 import (
+	"github.com/obnahsgnaw/rpc/pkg/rpcauth"
+	"github.com/obnahsgnaw/rpc/pkg/rpcclient"
+	"github.com/obnahsgnaw/rpc/pkg/rpclimit"
+	"github.com/obnahsgnaw/rpc/pkg/rpcserver"
+	"github.com/obnahsgnaw/rpc/pkg/rpcsign"
+	"github.com/obnahsgnaw/rpc/pkg/rpctls"
+	"github.com/obnahsgnaw/rpc/pkg/rpctrace"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"log"
+	"time"
 )
 
 type Option func(s *Server)
@@ -25,3 +35,133 @@ func ErrorWriter(w io.Writer) Option {
 		}
 	}
 }
+
+// RegWeight register the server with a weight for the weighted balancer of the callers,
+// the registered value is host:port?weight=N then, the readers take the host by rpcclient.ParseRegVal
+func RegWeight(w int) Option {
+	return func(s *Server) {
+		s.regWeight = w
+	}
+}
+
+// HealthCheck probe the discovered rpc addrs actively and exclude the unhealthy ones from the calls
+func HealthCheck(cnf *rpcclient.HealthCheckConfig) Option {
+	return func(s *Server) {
+		s.healthCheck = cnf
+	}
+}
+
+// DrainDelay the wait after deregistration on release, so the callers can see it before the server stop accepting
+func DrainDelay(d time.Duration) Option {
+	return func(s *Server) {
+		s.drainDelay = d
+	}
+}
+
+// DrainTimeout the max wait for the in-flight rpc on release before force stop, default 10s
+func DrainTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		if d > 0 {
+			s.drainTimeout = d
+		}
+	}
+}
+
+// ErrMode set how the handler errors are sent to the caller, default rpcserver.ErrorModeHeader
+func ErrMode(mode rpcserver.ErrorMode) Option {
+	return func(s *Server) {
+		s.errMode = mode
+	}
+}
+
+// Metrics record the prometheus metrics of the served and called rpc into reg, labeled with server=id
+func Metrics(reg prometheus.Registerer) Option {
+	return func(s *Server) {
+		if reg == nil {
+			reg = prometheus.DefaultRegisterer
+		}
+		s.metricsReg = reg
+	}
+}
+
+// Tracing create the opentelemetry spans of the served and called rpc by tp, tp nil for the otel global provider
+func Tracing(tp trace.TracerProvider) Option {
+	return func(s *Server) {
+		s.tracer = rpctrace.New(tp, nil)
+	}
+}
+
+// ServerTLS serve with TLS, and require the client certs verified by the CAFile if set, the certs are reloaded when modified.
+// The TLS rpc accepts on the whole port, so the server fails with the SharedListener or the parent server
+func ServerTLS(cnf *rpctls.Config) Option {
+	return func(s *Server) {
+		s.serverTLS = cnf
+	}
+}
+
+// SharedListener the listener is shared with the other servers on the port, like the http server,
+// the rpc with a parent server is always on the shared listener
+func SharedListener() Option {
+	return func(s *Server) {
+		s.sharedListener = true
+	}
+}
+
+// ClientTLS call the rpc servers with TLS, and send the client cert for the mutual TLS if the CertFile set
+func ClientTLS(cnf *rpctls.Config) Option {
+	return func(s *Server) {
+		s.clientTLS = cnf
+	}
+}
+
+// AccessControl enforce the module to module ACL of the yaml or json rules file, the file is reloaded when modified,
+// the calls not allowed are denied and logged, or only logged in the dry run
+func AccessControl(file string) Option {
+	return func(s *Server) {
+		s.aclFile = file
+	}
+}
+
+// Authenticate verify the authorization of the served rpc by the validators in order, the identity is in rpcauth.FromContext
+func Authenticate(validators ...rpcauth.Validator) Option {
+	return func(s *Server) {
+		s.validators = append(s.validators, validators...)
+	}
+}
+
+// CallCredentials attach the authorization of the provider to the called rpc
+func CallCredentials(p rpcclient.CredentialProvider) Option {
+	return func(s *Server) {
+		s.clientManager.SetDefaultCredentials(p)
+	}
+}
+
+// SignCalls sign the header of the called rpc by the key, set the per module keys or rotate by Signer()
+func SignCalls(key *rpcsign.Key) Option {
+	return func(s *Server) {
+		s.signer = rpcsign.NewSigner(key)
+	}
+}
+
+// VerifyCalls verify the signed header of the served rpc by the active keys of the from modules, * for the others,
+// window the max clock skew and the replay window, default 1 minute; rotate the keys by Verifier()
+func VerifyCalls(window time.Duration, keys map[string][]rpcsign.Key) Option {
+	return func(s *Server) {
+		s.verifyWindow = window
+		s.verifyKeys = keys
+	}
+}
+
+// RateLimit throttle the served rpc by the token bucket rules, change them at runtime by RateLimiter().SetConfig
+func RateLimit(cnf *rpclimit.Config) Option {
+	return func(s *Server) {
+		s.limitCnf = cnf
+	}
+}
+
+// RateLimitFile throttle the served rpc by the rules of the yaml or json file, the file is reloaded when modified
+func RateLimitFile(file string) Option {
+	return func(s *Server) {
+		s.limitFile = file
+	}
+}
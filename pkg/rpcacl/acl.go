@@ -0,0 +1,155 @@
+package rpcacl
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/obnahsgnaw/rpc/internal/rpcutil"
+	"github.com/obnahsgnaw/rpc/pkg/rpcserver"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// Rule allow the From module to call the Methods, like:
+//
+//	rules:
+//	  - from: order
+//	    methods: ["/user.User/Get", "/user.Address/*"]
+//	    app_ids: ["app1"]
+type Rule struct {
+	// From the caller module, * for all
+	From string `json:"from" yaml:"from"`
+	// Methods the full methods, /package.Service/* for all the methods of the service, * for all
+	Methods []string `json:"methods" yaml:"methods"`
+	// AppIds the rule only applies to the calls of the app ids, empty for all
+	AppIds []string `json:"app_ids" yaml:"app_ids"`
+}
+
+// Config the calls not allowed by any rule are denied
+type Config struct {
+	// DryRun log the denials without blocking, for auditing the rules before enforcing
+	DryRun bool   `json:"dry_run" yaml:"dry_run"`
+	Rules  []Rule `json:"rules" yaml:"rules"`
+}
+
+func (r *Rule) match(from, appId, fullMethod string) bool {
+	if r.From != "*" && r.From != from {
+		return false
+	}
+	if len(r.AppIds) > 0 && !contains(r.AppIds, appId) {
+		return false
+	}
+	for _, m := range r.Methods {
+		if m == "*" || m == fullMethod || strings.HasSuffix(m, "/*") && strings.HasPrefix(fullMethod, m[:len(m)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// ACL the module to module access control, the rules file is reloaded when modified
+type ACL struct {
+	sync.Mutex
+	cnf    *Config
+	loader *rpcutil.FileLoader
+	logger *zap.Logger
+}
+
+// New ACL with the rules, use SetConfig or Load to change them
+func New(cnf *Config, l *zap.Logger) *ACL {
+	if cnf == nil {
+		cnf = &Config{}
+	}
+	return &ACL{cnf: cnf, logger: l}
+}
+
+// Load the rules from the yaml or json(by the .json ext) file, the file is checked for modification each interval on the calls, default 10s
+func Load(file string, interval time.Duration, l *zap.Logger) (*ACL, error) {
+	a := New(nil, l)
+	loader, err := rpcutil.NewFileLoader("rpc acl", file, interval, a.apply, l)
+	if err != nil {
+		return nil, err
+	}
+	a.loader = loader
+	return a, nil
+}
+
+// SetConfig replace the rules, nil for no rules
+func (a *ACL) SetConfig(cnf *Config) {
+	if cnf == nil {
+		cnf = &Config{}
+	}
+	a.Lock()
+	defer a.Unlock()
+	a.cnf = cnf
+}
+
+// Config return the current rules
+func (a *ACL) Config() *Config {
+	if a.loader != nil {
+		a.loader.Refresh()
+	}
+	a.Lock()
+	defer a.Unlock()
+	return a.cnf
+}
+
+// Allowed return if the from module can call the method for the app id, and if it is in dry run
+func (a *ACL) Allowed(from, appId, fullMethod string) (allowed bool, dryRun bool) {
+	cnf := a.Config()
+	for i := range cnf.Rules {
+		if cnf.Rules[i].match(from, appId, fullMethod) {
+			return true, cnf.DryRun
+		}
+	}
+	return false, cnf.DryRun
+}
+
+// BeforeInterceptor the rpcserver.BeforeInterceptor enforcing the ACL, the denied calls fail with the 403 AccessError
+func (a *ACL) BeforeInterceptor() rpcserver.BeforeInterceptor {
+	return func(ctx context.Context, head rpcserver.Header, req interface{}, info *grpc.UnaryServerInfo) error {
+		return a.check(head, info.FullMethod)
+	}
+}
+
+// StreamBeforeInterceptor the rpcserver.StreamBeforeInterceptor enforcing the ACL
+func (a *ACL) StreamBeforeInterceptor() rpcserver.StreamBeforeInterceptor {
+	return func(ctx context.Context, head rpcserver.Header, info *grpc.StreamServerInfo) error {
+		return a.check(head, info.FullMethod)
+	}
+}
+
+func (a *ACL) check(head rpcserver.Header, fullMethod string) error {
+	allowed, dryRun := a.Allowed(head.From, head.AppId, fullMethod)
+	if allowed {
+		return nil
+	}
+	if a.logger != nil {
+		a.logger.Warn("rpc acl denied", zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.RqId), zap.String("app_id", head.AppId), zap.String("method", fullMethod), zap.Bool("dry_run", dryRun))
+	}
+	if dryRun {
+		return nil
+	}
+	return rpcserver.NewPermissionDeniedError("rpc acl: " + head.From + " is not allowed to call " + fullMethod)
+}
+
+// apply take the rules decoded from the file
+func (a *ACL) apply(decode func(v interface{}) error) error {
+	cnf := &Config{}
+	if err := decode(cnf); err != nil {
+		return err
+	}
+	a.SetConfig(cnf)
+	return nil
+}
@@ -0,0 +1,136 @@
+package rpcauth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/obnahsgnaw/rpc/pkg/rpcserver"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// ErrUnsupported returned by the Validator for the schemes not supported, the next validator is tried
+var ErrUnsupported = errors.New("rpc auth: unsupported credential")
+
+// Identity the verified identity of the caller
+type Identity struct {
+	// Scheme the lowercased scheme of the authorization, like bearer or apikey
+	Scheme string
+	// UserId and AppId the verified ids, empty for not asserted, the header ids are trusted then
+	UserId string
+	AppId  string
+	// Claims the jwt claims, nil for the api keys
+	Claims map[string]interface{}
+}
+
+// Validator verify the credential of the authorization "<scheme> <credential>" metadata
+type Validator interface {
+	Validate(scheme, credential string) (*Identity, error)
+}
+
+// Authenticator verify the authorization metadata of the inbound rpc by the validators in order,
+// the identity is bound into the handler ctx and cross-checked with the Header.UserId and AppId,
+// the calls failed the verification are rejected with the 401 AccessError
+type Authenticator struct {
+	validators []Validator
+	exempt     map[string]bool
+	logger     *zap.Logger
+}
+
+func New(l *zap.Logger, validators ...Validator) *Authenticator {
+	return &Authenticator{validators: validators, exempt: make(map[string]bool), logger: l}
+}
+
+// SetExempt the full methods served without authentication
+func (a *Authenticator) SetExempt(methods ...string) {
+	for _, m := range methods {
+		a.exempt[m] = true
+	}
+}
+
+// UnaryInterceptor register it by rpcserver.Server.RegisterUnaryInterceptor
+func (a *Authenticator) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamInterceptor register it by rpcserver.Server.RegisterStreamInterceptor
+func (a *Authenticator) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (a *Authenticator) authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	if a.exempt[fullMethod] {
+		return ctx, nil
+	}
+	head, _ := rpcserver.HeaderFromContext(ctx)
+	id, err := a.verify(ctx, head)
+	if err != nil {
+		if a.logger != nil {
+			a.logger.Warn("rpc auth failed, "+err.Error(), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.RqId), zap.String("app_id", head.AppId), zap.String("user_id", head.UserId), zap.String("method", fullMethod))
+		}
+		return ctx, rpcserver.NewUnauthenticatedError(err.Error())
+	}
+	return NewContext(ctx, id), nil
+}
+
+func (a *Authenticator) verify(ctx context.Context, head rpcserver.Header) (*Identity, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	auths := md.Get("authorization")
+	if len(auths) == 0 || auths[0] == "" {
+		return nil, errors.New("rpc auth: no credential")
+	}
+	scheme, credential, _ := strings.Cut(auths[0], " ")
+	scheme = strings.ToLower(scheme)
+	for _, v := range a.validators {
+		id, err := v.Validate(scheme, strings.TrimSpace(credential))
+		if errors.Is(err, ErrUnsupported) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		id.Scheme = scheme
+		if id.UserId != "" && head.UserId != "" && id.UserId != head.UserId {
+			return nil, errors.New("rpc auth: user id " + head.UserId + " not match the credential")
+		}
+		if id.AppId != "" && head.AppId != "" && id.AppId != head.AppId {
+			return nil, errors.New("rpc auth: app id " + head.AppId + " not match the credential")
+		}
+		return id, nil
+	}
+	return nil, errors.New("rpc auth: no validator for the " + scheme + " credential")
+}
+
+// NewContext return the ctx with the identity
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, "RpcIdentity", id)
+}
+
+// FromContext return the verified identity in the handler ctx
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value("RpcIdentity").(*Identity)
+	return id, ok
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
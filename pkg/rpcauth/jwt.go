@@ -0,0 +1,188 @@
+package rpcauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/obnahsgnaw/rpc/internal/rpcutil"
+)
+
+// JWTConfig the validation of the "Bearer <jwt>" authorization, the exp is required and the iat not in the future
+type JWTConfig struct {
+	// Issuer and Audience the iss and aud required, empty for not checked
+	Issuer   string
+	Audience string
+	// UserClaim and AppClaim the claims of the UserId and AppId, default sub and app_id
+	UserClaim string
+	AppClaim  string
+	// Leeway the clock skew allowed for exp, nbf and iat
+	Leeway time.Duration
+}
+
+type jwtValidator struct {
+	cnf     *JWTConfig
+	methods []string
+	key     func(token *jwt.Token) (interface{}, error)
+}
+
+// NewHMACValidator validate the HS256/384/512 jwt signed by the secret
+func NewHMACValidator(secret []byte, cnf *JWTConfig) Validator {
+	return &jwtValidator{cnf: cnf, methods: []string{"HS256", "HS384", "HS512"}, key: func(*jwt.Token) (interface{}, error) {
+		return secret, nil
+	}}
+}
+
+// NewJWKSValidator validate the RSA and ECDSA jwt by the keys of the JWKS file, the key is chosen by the kid header,
+// the json or yaml file is reloaded when modified, checked at most once per 10s, the current keys are kept if the new file is invalid
+func NewJWKSValidator(file string, cnf *JWTConfig) (Validator, error) {
+	s := &jwks{}
+	loader, err := rpcutil.NewFileLoader("rpc auth jwks", file, 0, s.apply, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.loader = loader
+	return &jwtValidator{cnf: cnf, methods: []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}, key: s.key}, nil
+}
+
+func (v *jwtValidator) Validate(scheme, credential string) (*Identity, error) {
+	if scheme != "bearer" {
+		return nil, ErrUnsupported
+	}
+	// the bearer values not jwt and the tokens of the other algs are left to the next validator
+	token, _, err := jwt.NewParser().ParseUnverified(credential, jwt.MapClaims{})
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	if !v.supports(token.Method.Alg()) {
+		return nil, ErrUnsupported
+	}
+	cnf := v.cnf
+	if cnf == nil {
+		cnf = &JWTConfig{}
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods(v.methods), jwt.WithLeeway(cnf.Leeway), jwt.WithExpirationRequired(), jwt.WithIssuedAt()}
+	if cnf.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cnf.Issuer))
+	}
+	if cnf.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cnf.Audience))
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(credential, claims, v.key, opts...); err != nil {
+		return nil, errors.New("rpc auth: invalid jwt, " + err.Error())
+	}
+	userClaim, appClaim := cnf.UserClaim, cnf.AppClaim
+	if userClaim == "" {
+		userClaim = "sub"
+	}
+	if appClaim == "" {
+		appClaim = "app_id"
+	}
+	id := &Identity{Claims: claims}
+	id.UserId, _ = claims[userClaim].(string)
+	id.AppId, _ = claims[appClaim].(string)
+	return id, nil
+}
+
+func (v *jwtValidator) supports(alg string) bool {
+	for _, m := range v.methods {
+		if m == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// jwks the public keys of a JWKS file by kid
+type jwks struct {
+	sync.Mutex
+	keys   map[string]interface{}
+	loader *rpcutil.FileLoader
+}
+
+func (s *jwks) key(token *jwt.Token) (interface{}, error) {
+	s.loader.Refresh()
+	s.Lock()
+	defer s.Unlock()
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, nil
+		}
+	}
+	if k, ok := s.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, errors.New("key " + kid + " not found")
+}
+
+// apply take the keys decoded from the file
+func (s *jwks) apply(decode func(v interface{}) error) error {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty" yaml:"kty"`
+			Kid string `json:"kid" yaml:"kid"`
+			Use string `json:"use" yaml:"use"`
+			N   string `json:"n" yaml:"n"`
+			E   string `json:"e" yaml:"e"`
+			Crv string `json:"crv" yaml:"crv"`
+			X   string `json:"x" yaml:"x"`
+			Y   string `json:"y" yaml:"y"`
+		} `json:"keys" yaml:"keys"`
+	}
+	if err := decode(&set); err != nil {
+		return err
+	}
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := decodeBigInt(k.N)
+			e, err2 := decodeBigInt(k.E)
+			if err1 != nil || err2 != nil {
+				return errors.New("rpc auth: invalid rsa key " + k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return errors.New("rpc auth: unsupported curve " + k.Crv)
+			}
+			x, err1 := decodeBigInt(k.X)
+			y, err2 := decodeBigInt(k.Y)
+			if err1 != nil || err2 != nil || !curve.IsOnCurve(x, y) {
+				return errors.New("rpc auth: invalid ec key " + k.Kid)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		}
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.keys = keys
+	return nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,33 @@
+package rpcauth
+
+import (
+	"crypto/subtle"
+	"errors"
+)
+
+type keyValidator struct {
+	keys map[string]Identity
+}
+
+// NewKeyValidator validate the static api keys of the "ApiKey <key>" authorization, the identity of the key is returned
+func NewKeyValidator(keys map[string]Identity) Validator {
+	return &keyValidator{keys: keys}
+}
+
+func (v *keyValidator) Validate(scheme, credential string) (*Identity, error) {
+	if scheme != "apikey" {
+		return nil, ErrUnsupported
+	}
+	var matched *Identity
+	// compare all the keys in constant time, so the key is not guessed by the timing
+	for key, id := range v.keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(credential)) == 1 {
+			id := id
+			matched = &id
+		}
+	}
+	if matched == nil {
+		return nil, errors.New("rpc auth: invalid api key")
+	}
+	return matched, nil
+}
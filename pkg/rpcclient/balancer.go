@@ -0,0 +1,199 @@
+package rpcclient
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/obnahsgnaw/application/pkg/utils"
+)
+
+// Endpoint a module server addr and the value registered with it
+type Endpoint struct {
+	Addr string
+	Val  string
+}
+
+// Balancer pick a module server addr for a call
+type Balancer interface {
+	// Pick return one addr of the list, the list is not empty
+	Pick(ctx context.Context, head Header, list []Endpoint) string
+	// Done called when the call to the picked addr finished
+	Done(module Module, addr string, err error)
+}
+
+// RandomBalancer pick an addr uniformly at random
+type RandomBalancer struct{}
+
+func NewRandomBalancer() *RandomBalancer {
+	return &RandomBalancer{}
+}
+
+func (b *RandomBalancer) Pick(_ context.Context, _ Header, list []Endpoint) string {
+	return list[utils.RandInt(len(list))].Addr
+}
+
+func (b *RandomBalancer) Done(Module, string, error) {}
+
+// RoundRobinBalancer pick the addr in turn for each module
+type RoundRobinBalancer struct {
+	sync.Mutex
+	next map[Module]int
+}
+
+func NewRoundRobinBalancer() *RoundRobinBalancer {
+	return &RoundRobinBalancer{next: make(map[Module]int)}
+}
+
+func (b *RoundRobinBalancer) Pick(_ context.Context, head Header, list []Endpoint) string {
+	b.Lock()
+	defer b.Unlock()
+	module := Module(head.To)
+	i := b.next[module] % len(list)
+	b.next[module] = i + 1
+	return list[i].Addr
+}
+
+func (b *RoundRobinBalancer) Done(Module, string, error) {}
+
+// WeightedRandomBalancer pick an addr at random in proportion to the weight parsed from the registered value
+type WeightedRandomBalancer struct {
+	weight func(val string) int
+}
+
+// NewWeightedRandomBalancer the default weight parser is ParseWeight
+func NewWeightedRandomBalancer(weight func(val string) int) *WeightedRandomBalancer {
+	if weight == nil {
+		weight = ParseWeight
+	}
+	return &WeightedRandomBalancer{weight: weight}
+}
+
+func (b *WeightedRandomBalancer) Pick(_ context.Context, _ Header, list []Endpoint) string {
+	weights := make([]int, len(list))
+	total := 0
+	for i, e := range list {
+		if w := b.weight(e.Val); w > 0 {
+			weights[i] = w
+			total += w
+		}
+	}
+	if total == 0 {
+		return list[utils.RandInt(len(list))].Addr
+	}
+	n := utils.RandInt(total)
+	for i, w := range weights {
+		if n < w {
+			return list[i].Addr
+		}
+		n -= w
+	}
+	return list[len(list)-1].Addr
+}
+
+func (b *WeightedRandomBalancer) Done(Module, string, error) {}
+
+// ParseWeight parse the weight=N param of a registered value like 127.0.0.1:8001?weight=10, default 1
+func ParseWeight(val string) int {
+	_, w := ParseRegVal(val)
+	return w
+}
+
+// ParseRegVal split a registered value like 127.0.0.1:8001?weight=10 to the host and the weight, default 1,
+// the readers of the registered value must take the host by it
+func ParseRegVal(val string) (host string, weight int) {
+	host, query, _ := strings.Cut(val, "?")
+	for _, kv := range strings.Split(query, "&") {
+		if strings.HasPrefix(kv, "weight=") {
+			if w, err := strconv.Atoi(strings.TrimPrefix(kv, "weight=")); err == nil {
+				return host, w
+			}
+		}
+	}
+	return host, 1
+}
+
+// outstanding the in-flight call count of each addr
+type outstanding struct {
+	sync.Mutex
+	count map[Module]map[string]int
+}
+
+func (o *outstanding) get(module Module, addr string) int {
+	return o.count[module][addr]
+}
+
+func (o *outstanding) inc(module Module, addr string) {
+	if o.count == nil {
+		o.count = make(map[Module]map[string]int)
+	}
+	if _, ok := o.count[module]; !ok {
+		o.count[module] = make(map[string]int)
+	}
+	o.count[module][addr]++
+}
+
+func (o *outstanding) Done(module Module, addr string, _ error) {
+	o.Lock()
+	defer o.Unlock()
+	if o.count[module][addr] > 1 {
+		o.count[module][addr]--
+	} else {
+		delete(o.count[module], addr)
+	}
+}
+
+// LeastRequestBalancer pick the addr with the least in-flight calls
+type LeastRequestBalancer struct {
+	outstanding
+}
+
+func NewLeastRequestBalancer() *LeastRequestBalancer {
+	return &LeastRequestBalancer{}
+}
+
+func (b *LeastRequestBalancer) Pick(_ context.Context, head Header, list []Endpoint) string {
+	b.Lock()
+	defer b.Unlock()
+	module := Module(head.To)
+	// start at a random offset so the ties are broken at random
+	offset := utils.RandInt(len(list))
+	addr := list[offset].Addr
+	least := b.get(module, addr)
+	for i := 1; i < len(list) && least > 0; i++ {
+		e := list[(offset+i)%len(list)]
+		if n := b.get(module, e.Addr); n < least {
+			addr, least = e.Addr, n
+		}
+	}
+	b.inc(module, addr)
+	return addr
+}
+
+// P2CBalancer pick two addrs at random and take the one with fewer in-flight calls
+type P2CBalancer struct {
+	outstanding
+}
+
+func NewP2CBalancer() *P2CBalancer {
+	return &P2CBalancer{}
+}
+
+func (b *P2CBalancer) Pick(_ context.Context, head Header, list []Endpoint) string {
+	b.Lock()
+	defer b.Unlock()
+	module := Module(head.To)
+	addr := list[utils.RandInt(len(list))].Addr
+	if len(list) > 1 {
+		i := utils.RandInt(len(list) - 1)
+		if list[i].Addr == addr {
+			i = len(list) - 1
+		}
+		if b.get(module, list[i].Addr) < b.get(module, addr) {
+			addr = list[i].Addr
+		}
+	}
+	b.inc(module, addr)
+	return addr
+}
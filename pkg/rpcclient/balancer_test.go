@@ -0,0 +1,19 @@
+package rpcclient
+
+import "testing"
+
+func TestParseRegVal(t *testing.T) {
+	for val, want := range map[string]struct {
+		host   string
+		weight int
+	}{
+		"127.0.0.1:8001?weight=10":       {"127.0.0.1:8001", 10},
+		"127.0.0.1:8001?zone=a&weight=3": {"127.0.0.1:8001", 3},
+		"127.0.0.1:8001?weight=x":        {"127.0.0.1:8001", 1},
+		"127.0.0.1:8001":                 {"127.0.0.1:8001", 1},
+	} {
+		if host, weight := ParseRegVal(val); host != want.host || weight != want.weight {
+			t.Fatalf("%s: want %s and %d, got %s and %d", val, want.host, want.weight, host, weight)
+		}
+	}
+}
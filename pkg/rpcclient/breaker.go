@@ -0,0 +1,251 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BreakerConfig the circuit breaker of each module addr
+type BreakerConfig struct {
+	// FailureRatio open the breaker when the failure ratio in the window reach it, default 0.5
+	FailureRatio float64
+	// MinRequests the min calls in the window before the ratio is checked, default 10
+	MinRequests int
+	// Window the duration the calls are counted in, default 10s
+	Window time.Duration
+	// OpenTimeout the duration the breaker keep open before half-open, default 5s
+	OpenTimeout time.Duration
+	// HalfOpenRequests the probe calls allowed in half-open, default 1
+	HalfOpenRequests int
+}
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type breaker struct {
+	sync.Mutex
+	ratio       float64
+	minRequests int
+	window      time.Duration
+	openTimeout time.Duration
+	maxProbes   int
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int
+}
+
+func newBreaker(cnf *BreakerConfig) *breaker {
+	b := &breaker{
+		ratio:       cnf.FailureRatio,
+		minRequests: cnf.MinRequests,
+		window:      cnf.Window,
+		openTimeout: cnf.OpenTimeout,
+		maxProbes:   cnf.HalfOpenRequests,
+		windowStart: time.Now(),
+	}
+	if b.ratio <= 0 || b.ratio > 1 {
+		b.ratio = 0.5
+	}
+	if b.minRequests <= 0 {
+		b.minRequests = 10
+	}
+	if b.window <= 0 {
+		b.window = 10 * time.Second
+	}
+	if b.openTimeout <= 0 {
+		b.openTimeout = 5 * time.Second
+	}
+	if b.maxProbes <= 0 {
+		b.maxProbes = 1
+	}
+	return b
+}
+
+// current return the state, move open to half-open when timeout
+func (b *breaker) current() BreakerState {
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.openTimeout {
+		b.state = BreakerHalfOpen
+		b.probes = 0
+	}
+	return b.state
+}
+
+func (b *breaker) available() bool {
+	if b == nil {
+		return true
+	}
+	b.Lock()
+	defer b.Unlock()
+	switch b.current() {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return b.probes < b.maxProbes
+	default:
+		return true
+	}
+}
+
+// allow take a probe slot in half-open
+func (b *breaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.Lock()
+	defer b.Unlock()
+	switch b.current() {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.probes >= b.maxProbes {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+func (b *breaker) done(err error) {
+	if b == nil {
+		return
+	}
+	failed := isBreakerFailure(err)
+	b.Lock()
+	defer b.Unlock()
+	switch b.current() {
+	case BreakerHalfOpen:
+		if failed {
+			b.trip()
+		} else {
+			b.state = BreakerClosed
+			b.reset()
+		}
+	case BreakerClosed:
+		if time.Since(b.windowStart) >= b.window {
+			b.reset()
+		}
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.minRequests && float64(b.failures)/float64(b.requests) >= b.ratio {
+			b.trip()
+		}
+	}
+}
+
+// release give back the probe slot taken by allow without an outcome, for the attempts not reached the addr
+func (b *breaker) release() {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.current() == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *breaker) trip() {
+	b.state = BreakerOpen
+	b.openedAt = time.Now()
+	b.reset()
+}
+
+func (b *breaker) reset() {
+	b.windowStart = time.Now()
+	b.requests = 0
+	b.failures = 0
+	b.probes = 0
+}
+
+// isBreakerFailure the custom errors are the business result, the canceled calls are the caller's choice
+// and the throttled calls are the healthy server shedding the caller's excess, none count
+func isBreakerFailure(err error) bool {
+	// the grpc returns the Canceled status for the canceled ctx, it does not wrap the context.Canceled
+	if err == nil || errors.Is(err, context.Canceled) || status.Code(err) == codes.Canceled || errors.Is(err, ErrThrottled) {
+		return false
+	}
+	var customErr *CustomError
+	return !errors.As(err, &customErr)
+}
+
+// SetBreaker set the circuit breaker config of a module, nil to use the default
+func (m *Manager) SetBreaker(module Module, cnf *BreakerConfig) {
+	m.Lock()
+	defer m.Unlock()
+	if cnf == nil {
+		delete(m.breakerConfigs, module)
+	} else {
+		m.breakerConfigs[module] = cnf
+	}
+	delete(m.breakers, module)
+}
+
+// SetDefaultBreaker set the circuit breaker config for modules without their own, nil to disable, default disabled
+func (m *Manager) SetDefaultBreaker(cnf *BreakerConfig) {
+	m.Lock()
+	defer m.Unlock()
+	m.defaultBreaker = cnf
+	m.breakers = make(map[Module]map[string]*breaker)
+}
+
+// BreakerState return the circuit breaker state of the module addrs
+func (m *Manager) BreakerState(module Module) map[string]BreakerState {
+	m.Lock()
+	defer m.Unlock()
+	states := make(map[string]BreakerState)
+	for addr := range m.addrMap[module] {
+		states[addr] = BreakerClosed
+		if b := m.breakers[module][addr]; b != nil {
+			b.Lock()
+			states[addr] = b.current()
+			b.Unlock()
+		}
+	}
+	return states
+}
+
+// getBreaker return the breaker of the module addr, nil if disabled
+func (m *Manager) getBreaker(module Module, addr string) *breaker {
+	m.Lock()
+	defer m.Unlock()
+	cnf, ok := m.breakerConfigs[module]
+	if !ok {
+		cnf = m.defaultBreaker
+	}
+	if cnf == nil {
+		return nil
+	}
+	if _, ok = m.breakers[module]; !ok {
+		m.breakers[module] = make(map[string]*breaker)
+	}
+	if _, ok = m.breakers[module][addr]; !ok {
+		m.breakers[module][addr] = newBreaker(cnf)
+	}
+	return m.breakers[module][addr]
+}
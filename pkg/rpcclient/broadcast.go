@@ -0,0 +1,107 @@
+package rpcclient
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// BroadcastConfig the config of a broadcast call to every addr of a module
+type BroadcastConfig struct {
+	// Concurrency the max addrs called at the same time, default 0 for all
+	Concurrency int
+	// Timeout of each addr call, default 0 for the call ttl
+	Timeout time.Duration
+	// Quorum the succeeded addrs required, the rest calls are canceled once it is reached or can not be reached,
+	// default 0 for all the addrs
+	Quorum int
+}
+
+// BroadcastResult the result of an addr, the Err is the ctx error for the addr canceled or not called
+type BroadcastResult struct {
+	Addr string
+	Val  interface{}
+	Err  error
+}
+
+// Broadcast call every addr of the module concurrently, return the results sorted by addr,
+// and the RpsError of ErrQuorum if the succeeded addrs are less than the quorum
+func (m *Manager) Broadcast(ctx context.Context, from, to, rqId, appid, uid string, cnf *BroadcastConfig, cb func(context.Context, *grpc.ClientConn) (interface{}, error)) ([]BroadcastResult, error) {
+	if cb == nil {
+		return nil, NewRpsError("callback is nil")
+	}
+	var c BroadcastConfig
+	if cnf != nil {
+		c = *cnf
+	}
+	addrs := m.Get(Module(to))
+	if len(addrs) == 0 {
+		return nil, newRpsError(ErrNoAddr)
+	}
+	sort.Strings(addrs)
+	if c.Quorum <= 0 || c.Quorum > len(addrs) {
+		c.Quorum = len(addrs)
+	}
+	if c.Concurrency <= 0 || c.Concurrency > len(addrs) {
+		c.Concurrency = len(addrs)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]BroadcastResult, len(addrs))
+	sem := make(chan struct{}, c.Concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var succeeded, failed int
+	for i, addr := range addrs {
+		results[i].Addr = addr
+		if ctx.Err() == nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			results[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ctx1 := ctx
+			if c.Timeout > 0 {
+				var cl context.CancelFunc
+				ctx1, cl = context.WithTimeout(ctx, c.Timeout)
+				defer cl()
+			}
+			val, err := m.HostValCall(ctx1, addr, 1, from, to, rqId, appid, uid, cb)
+			mu.Lock()
+			defer mu.Unlock()
+			results[i].Val, results[i].Err = val, err
+			if err == nil {
+				succeeded++
+			} else {
+				failed++
+			}
+			if succeeded >= c.Quorum || failed > len(addrs)-c.Quorum {
+				cancel()
+			}
+		}(i, addr)
+	}
+	wg.Wait()
+	if succeeded < c.Quorum {
+		return results, newRpsError(fmt.Errorf("%w, module %s needed %d succeeded %d", ErrQuorum, to, c.Quorum, succeeded))
+	}
+	return results, nil
+}
+
+// CtxBroadcast broadcast with the header resolved from ctx like CtxValCall
+func (m *Manager) CtxBroadcast(ctx context.Context, to string, cnf *BroadcastConfig, cb func(context.Context, *grpc.ClientConn) (interface{}, error), opts ...CallOption) ([]BroadcastResult, error) {
+	ctx, head := m.ctxHeader(ctx, to, opts)
+	return m.Broadcast(ctx, head.From, head.To, head.RqId, head.AppId, head.UserId, cnf, cb)
+}
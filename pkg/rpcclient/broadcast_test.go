@@ -0,0 +1,34 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestBroadcastQuorum(t *testing.T) {
+	m := NewManager()
+	m.Add("user", "127.0.0.1:8001")
+	m.Add("user", "127.0.0.1:8002")
+	m.Add("user", "127.0.0.1:8003")
+	calls := 0
+	results, err := m.Broadcast(context.Background(), "order", "user", "r1", "", "", &BroadcastConfig{Concurrency: 1, Quorum: 2}, func(ctx context.Context, cc *grpc.ClientConn) (interface{}, error) {
+		calls++
+		if calls == 1 {
+			return "ok", nil
+		}
+		return nil, errors.New("down")
+	})
+	var rpsErr *RpsError
+	if !errors.Is(err, ErrQuorum) || !errors.As(err, &rpsErr) {
+		t.Fatalf("want the RpsError of ErrQuorum, got %v", err)
+	}
+	if err.Error() != "broadcast quorum not reached, module user needed 2 succeeded 1" {
+		t.Fatalf("unexpected error message %s", err.Error())
+	}
+	if len(results) != 3 || results[0].Val != "ok" {
+		t.Fatalf("want the results of all the addrs, got %v", results)
+	}
+}
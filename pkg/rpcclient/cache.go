@@ -0,0 +1,196 @@
+package rpcclient
+
+import (
+	"container/list"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// CacheConfig the response cache of a module, the key is module + full method + "#" + app id + "#" + user id + "#" + authorization + "#" + serialized request,
+// like user/user.User/Get#app#uid#Bearer xx#..., so the callers with different credentials not share the responses, the hits are answered before the invoker and not reported to the breaker of the addr
+type CacheConfig struct {
+	// MaxEntries the max responses cached, the least recently used are evicted, default 1000
+	MaxEntries int
+	// TTLs the ttl of the full methods, the methods not set are cached only by the cache_ttl header of the server
+	TTLs map[string]time.Duration
+	// NegativeTTL the ttl of the CustomError with the NegativeStatus, default 0 not cached
+	NegativeTTL time.Duration
+	// NegativeStatus the status of the CustomError cached, default 404
+	NegativeStatus []string
+}
+
+func (c *CacheConfig) negative(err error) bool {
+	var customErr *CustomError
+	if c.NegativeTTL <= 0 || !errors.As(err, &customErr) {
+		return false
+	}
+	statuses := c.NegativeStatus
+	if len(statuses) == 0 {
+		statuses = []string{"404"}
+	}
+	for _, st := range statuses {
+		if customErr.status == st {
+			return true
+		}
+	}
+	return false
+}
+
+type cacheEntry struct {
+	key     string
+	reply   proto.Message
+	err     error
+	expires time.Time
+}
+
+// responseCache a lru cache of the responses of a module
+type responseCache struct {
+	sync.Mutex
+	cnf     *CacheConfig
+	max     int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func newResponseCache(cnf *CacheConfig) *responseCache {
+	c := &responseCache{cnf: cnf, max: cnf.MaxEntries, entries: make(map[string]*list.Element), lru: list.New()}
+	if c.max <= 0 {
+		c.max = 1000
+	}
+	return c
+}
+
+func (c *responseCache) get(key string) (*cacheEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+func (c *responseCache) put(e *cacheEntry) {
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.max {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *responseCache) invalidate(prefix string) {
+	c.Lock()
+	defer c.Unlock()
+	for key, el := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.lru.Remove(el)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// SetCache enable the response cache of a module, nil to disable and drop the cached responses
+func (m *Manager) SetCache(module Module, cnf *CacheConfig) {
+	m.Lock()
+	defer m.Unlock()
+	if cnf == nil {
+		delete(m.caches, module)
+	} else {
+		m.caches[module] = newResponseCache(cnf)
+	}
+}
+
+// InvalidateCache drop the cached responses with the key prefix, like user/user.User/Get for all the Get requests
+func (m *Manager) InvalidateCache(prefix string) {
+	m.Lock()
+	caches := make([]*responseCache, 0, len(m.caches))
+	for _, c := range m.caches {
+		caches = append(caches, c)
+	}
+	m.Unlock()
+	for _, c := range caches {
+		c.invalidate(prefix)
+	}
+}
+
+func (m *Manager) cache(module Module) *responseCache {
+	m.Lock()
+	defer m.Unlock()
+	return m.caches[module]
+}
+
+// cacheKey return the cache and key of the call, nil if the module is not cached
+func (m *Manager) cacheKey(head Header, authorization, method string, req, reply interface{}) (*responseCache, string) {
+	c := m.cache(Module(head.To))
+	if c == nil {
+		return nil, ""
+	}
+	msg, ok := req.(proto.Message)
+	if _, ok1 := reply.(proto.Message); !ok || !ok1 {
+		return nil, ""
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return nil, ""
+	}
+	return c, head.To + method + "#" + head.AppId + "#" + head.UserId + "#" + authorization + "#" + string(b)
+}
+
+// cacheGet copy the cached reply, return true and the cached error if hit
+func (m *Manager) cacheGet(c *responseCache, key string, reply interface{}) (bool, error) {
+	e, ok := c.get(key)
+	if !ok {
+		return false, nil
+	}
+	if e.err != nil {
+		return true, copyErr(e.err)
+	}
+	proto.Reset(reply.(proto.Message))
+	proto.Merge(reply.(proto.Message), e.reply)
+	return true, nil
+}
+
+// cachePut cache the reply or the negative error, the cache_ttl header of the server take precedence over the method ttl
+func (m *Manager) cachePut(c *responseCache, key, method string, md metadata.MD, reply interface{}, err error) {
+	ttl := c.cnf.TTLs[method]
+	if ttls := md.Get("cache_ttl"); len(ttls) > 0 {
+		if seconds, err1 := strconv.Atoi(ttls[0]); err1 == nil {
+			ttl = time.Duration(seconds) * time.Second
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+	e := &cacheEntry{key: key}
+	if err == nil {
+		e.reply = proto.Clone(reply.(proto.Message))
+		e.expires = time.Now().Add(ttl)
+	} else if c.cnf.negative(err) {
+		e.err = copyErr(err)
+		e.expires = time.Now().Add(c.cnf.NegativeTTL)
+	} else {
+		return
+	}
+	c.put(e)
+}
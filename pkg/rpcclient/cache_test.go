@@ -0,0 +1,42 @@
+package rpcclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCacheKeyAuthorization(t *testing.T) {
+	m := NewManager()
+	m.SetCache("user", &CacheConfig{TTLs: map[string]time.Duration{"/user.User/Get": time.Minute}})
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		md, _ := metadata.FromOutgoingContext(ctx)
+		reply.(*wrapperspb.StringValue).Value = md.Get("authorization")[0]
+		return nil
+	}
+	call := func(token string) string {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "rq_to", "user", "app_id", "app", "user_id", "u1")
+		ctx = WithCredential(newRpcMetadataContext(ctx), "Bearer "+token)
+		reply := &wrapperspb.StringValue{}
+		if err := m.unaryInterceptor(ctx, "/user.User/Get", wrapperspb.String("u1"), reply, nil, invoker); err != nil {
+			t.Fatal(err)
+		}
+		return reply.Value
+	}
+
+	if got := call("t1"); got != "Bearer t1" {
+		t.Fatalf("want the reply of t1, got %s", got)
+	}
+	if got := call("t2"); got != "Bearer t2" {
+		t.Fatalf("the caller of t2 got the cached reply %s", got)
+	}
+	if got := call("t1"); got != "Bearer t1" || calls != 2 {
+		t.Fatalf("want the cached reply of t1 after 2 calls, got %s after %d", got, calls)
+	}
+}
@@ -3,11 +3,18 @@ package rpcclient
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/obnahsgnaw/application/pkg/utils"
+	"github.com/obnahsgnaw/rpc/internal/rpcutil"
+	"github.com/obnahsgnaw/rpc/pkg/rpcmeta"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -22,16 +29,44 @@ type Header struct {
 	To     string
 	AppId  string
 	UserId string
+	// Meta the registered rpcmeta keys in ctx
+	Meta map[string]string
 }
 
 // Manager rpc server addr manager
 type Manager struct {
 	sync.Mutex
 	addrMap            map[Module]Addr
+	vals               map[Module]map[string]string
+	balancers          map[Module]Balancer
+	defaultBalancer    Balancer
+	retryPolicies      map[string]*RetryPolicy
+	hedgePolicies      map[Module]*HedgePolicy
+	latencies          map[Module]*latencyWindow
+	coalesceModules    map[Module]bool
+	caches             map[Module]*responseCache
+	coalesceMu         sync.Mutex
+	coalescing         map[string]*coalesceCall
+	breakerConfigs     map[Module]*BreakerConfig
+	defaultBreaker     *BreakerConfig
+	breakers           map[Module]map[string]*breaker
+	health             map[Module]map[string]*healthState
+	healthCancel       context.CancelFunc
 	beforeInterceptors []BeforeInterceptor
 	afterHandlers      []AfterHandler
+	streamBefores      []StreamBeforeInterceptor
+	streamAfters       []StreamAfterHandler
+	streamMsgHandlers  []StreamMsgHandler
+	unaryInterceptors  []grpc.UnaryClientInterceptor
+	streamInterceptors []grpc.StreamClientInterceptor
+	headerResolver     HeaderResolver
 	callTtl            time.Duration
+	streamIdleTtl      time.Duration
 	errBuilder         func(code, message, statusCode string) error
+	creds              credentials.TransportCredentials
+	authority          string
+	credentials        map[Module]CredentialProvider
+	defaultCredentials CredentialProvider
 }
 
 type RpcMetadata struct {
@@ -49,17 +84,41 @@ func getRpcMetadataContext(ctx context.Context) *RpcMetadata {
 
 // NewManager return a new addr manager
 func NewManager() *Manager {
-	return &Manager{addrMap: make(map[Module]Addr), callTtl: time.Second * 3}
+	return &Manager{
+		addrMap:         make(map[Module]Addr),
+		vals:            make(map[Module]map[string]string),
+		balancers:       make(map[Module]Balancer),
+		defaultBalancer: NewRandomBalancer(),
+		retryPolicies:   make(map[string]*RetryPolicy),
+		hedgePolicies:   make(map[Module]*HedgePolicy),
+		latencies:       make(map[Module]*latencyWindow),
+		coalesceModules: make(map[Module]bool),
+		caches:          make(map[Module]*responseCache),
+		coalescing:      make(map[string]*coalesceCall),
+		breakerConfigs:  make(map[Module]*BreakerConfig),
+		breakers:        make(map[Module]map[string]*breaker),
+		health:          make(map[Module]map[string]*healthState),
+		credentials:     make(map[Module]CredentialProvider),
+		callTtl:         time.Second * 3,
+		streamIdleTtl:   time.Minute,
+	}
 }
 
 // Add add a module server addr
 func (m *Manager) Add(module Module, addr string) {
+	m.AddWithVal(module, addr, "")
+}
+
+// AddWithVal add a module server addr with the value registered, the value is used by balancer like the weighted one
+func (m *Manager) AddWithVal(module Module, addr, val string) {
 	m.Lock()
 	defer m.Unlock()
 	if _, ok := m.addrMap[module]; !ok {
 		m.addrMap[module] = make(Addr)
+		m.vals[module] = make(map[string]string)
 	}
 	m.addrMap[module].Add(addr)
+	m.vals[module][addr] = val
 }
 
 // Rm remove a module server addr
@@ -74,6 +133,9 @@ func (m *Manager) Rm(module Module, addr string) {
 				}
 			}
 			delete(m.addrMap[module], addr)
+			delete(m.vals[module], addr)
+			delete(m.breakers[module], addr)
+			delete(m.health[module], addr)
 		}
 	}
 }
@@ -90,15 +152,126 @@ func (m *Manager) Get(module Module) (addrList []string) {
 	return
 }
 
-// GetRand return one module server addr or empty if not
+// Modules return the modules with server addr
+func (m *Manager) Modules() (modules []Module) {
+	m.Lock()
+	defer m.Unlock()
+	for module, addr := range m.addrMap {
+		if len(addr) > 0 {
+			modules = append(modules, module)
+		}
+	}
+	return
+}
+
+// GetRand return one healthy module server addr or empty if not
 func (m *Manager) GetRand(module Module) string {
-	list := m.Get(module)
+	var list []string
+	for _, addr := range m.Get(module) {
+		if m.healthy(module, addr) {
+			list = append(list, addr)
+		}
+	}
 	if len(list) > 0 {
 		return list[utils.RandInt(len(list))]
 	}
 	return ""
 }
 
+// Endpoints return module server addr list with the registered value, ordered by addr
+func (m *Manager) Endpoints(module Module) (list []Endpoint) {
+	m.Lock()
+	defer m.Unlock()
+	for addr := range m.addrMap[module] {
+		list = append(list, Endpoint{Addr: addr, Val: m.vals[module][addr]})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Addr < list[j].Addr
+	})
+	return
+}
+
+// SetBalancer set the balancer of a module
+func (m *Manager) SetBalancer(module Module, b Balancer) {
+	m.Lock()
+	defer m.Unlock()
+	if b == nil {
+		delete(m.balancers, module)
+	} else {
+		m.balancers[module] = b
+	}
+}
+
+// SetDefaultBalancer set the balancer for modules without their own, default random
+func (m *Manager) SetDefaultBalancer(b Balancer) {
+	m.Lock()
+	defer m.Unlock()
+	if b != nil {
+		m.defaultBalancer = b
+	}
+}
+
+func (m *Manager) balancer(module Module) Balancer {
+	m.Lock()
+	defer m.Unlock()
+	if b, ok := m.balancers[module]; ok {
+		return b
+	}
+	return m.defaultBalancer
+}
+
+// pick return an addr of the target module by the balancer and the func to report the call result, local for the result
+// not got from the addr, which only release the addr. The unhealthy addrs and the addrs with open breaker are skipped and the excluded addrs are picked only if no other
+func (m *Manager) pick(ctx context.Context, head Header, exclude ...string) (string, func(err error, local bool), error) {
+	module := Module(head.To)
+	var list, available, rest []Endpoint
+	all := m.Endpoints(module)
+	for _, e := range all {
+		if m.healthy(module, e.Addr) {
+			list = append(list, e)
+		}
+	}
+	if len(list) == 0 {
+		return "", nil, newRpsError(ErrNoAddr)
+	}
+	for _, e := range list {
+		if m.getBreaker(module, e.Addr).available() {
+			available = append(available, e)
+		}
+	}
+	if len(available) == 0 {
+		return "", nil, newRpsError(ErrBreakerOpen)
+	}
+next:
+	for _, e := range available {
+		for _, addr := range exclude {
+			if e.Addr == addr {
+				continue next
+			}
+		}
+		rest = append(rest, e)
+	}
+	if len(rest) == 0 {
+		rest = available
+	}
+	b := m.balancer(module)
+	addr := b.Pick(newEndpointsContext(ctx, all), head, rest)
+	br := m.getBreaker(module, addr)
+	if !br.allow() {
+		b.Done(module, addr, nil)
+		return "", nil, newRpsError(ErrBreakerOpen)
+	}
+	return addr, func(err error, local bool) {
+		if local {
+			b.Done(module, addr, nil)
+			br.release()
+			return
+		}
+		b.Done(module, addr, err)
+		br.done(err)
+	}, nil
+}
+
 // GetConn return rpc conn
 func (m *Manager) GetConn(module Module, addr string, tag int) (*grpc.ClientConn, error) {
 	m.Lock()
@@ -128,9 +301,7 @@ func (m *Manager) RegisterAfterHandler(h AfterHandler) {
 }
 
 func (m *Manager) newClient(server string) (*grpc.ClientConn, error) {
-	return grpc.Dial(
-		server,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	opts := append(m.dialOptions(),
 		grpc.WithKeepaliveParams(
 			keepalive.ClientParameters{
 				Time:                100 * time.Second,
@@ -138,57 +309,188 @@ func (m *Manager) newClient(server string) (*grpc.ClientConn, error) {
 				PermitWithoutStream: true,
 			},
 		),
-		grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
-			var mt *RpcMetadata
-			defer func() {
-				if err == nil {
-					errCode := "1"
-					errStatus := "500"
-					errMessage := ""
-					if mt != nil {
-						errMessages := mt.Header.Get("err_message")
-						if len(errMessages) > 0 {
-							errMessage = errMessages[0]
-						}
-						errCodes := mt.Header.Get("err_code")
-						if len(errCodes) > 0 {
-							errCode = errCodes[0]
-						}
-						errStatuss := mt.Header.Get("err_status")
-						if len(errStatuss) > 0 {
-							errStatus = errStatuss[0]
-						}
-					}
-					if errMessage != "" {
-						if m.errBuilder != nil {
-							err = m.errBuilder(errCode, errMessage, errStatus)
-						} else {
-							err = errors.New(errMessage + "[" + errStatus + " " + errCode + "]")
-						}
-						err = NewCustomError(err)
-					}
-				}
-			}()
-			header := m.parseHeader(ctx)
-			for _, h := range m.beforeInterceptors {
-				if err = h(ctx, header, method, req, cc, opts...); err != nil {
-					return
-				}
+		grpc.WithUnaryInterceptor(m.unaryInterceptor),
+		grpc.WithStreamInterceptor(m.streamInterceptor),
+	)
+	return grpc.Dial(server, opts...)
+}
+
+func (m *Manager) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+	if isHealthProbeContext(ctx) {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	header := m.parseHeader(ctx)
+	if info := getCallInfoContext(ctx); info != nil {
+		info.setMethod(method)
+	}
+	ctx, authorization, err := m.withCredentials(ctx, header)
+	if err != nil {
+		return m.fail(ctx, header, method, req, reply, cc, err, opts...)
+	}
+	cache, cacheKey := m.cacheKey(header, authorization, method, req, reply)
+	if cache != nil {
+		if hit, err := m.cacheGet(cache, cacheKey, reply); hit {
+			markLocal(ctx)
+			return err
+		}
+	}
+	invoke := func(ctx context.Context, reply interface{}) error {
+		err := m.invoke(ctx, header, method, req, reply, cc, invoker, opts...)
+		if cache != nil {
+			m.cachePut(cache, cacheKey, method, getRpcMetadataContext(ctx).Header, reply, err)
+		}
+		return err
+	}
+	if key, ok := m.coalesceKey(ctx, header, authorization, method, req, reply); ok {
+		return m.coalesce(ctx, key, reply, invoke)
+	}
+	return invoke(ctx, reply)
+}
+
+// invoke run the interceptors, before interceptors, invoker and after handlers of an attempt
+func (m *Manager) invoke(ctx context.Context, header Header, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	mt := getRpcMetadataContext(ctx)
+	return m.chainUnary(ctx, method, req, reply, cc, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) (err error) {
+		defer func() {
+			if err == nil {
+				err = m.parseErr(mt.Header)
+			} else {
+				err = m.parseStatusErr(err)
 			}
-			mt = getRpcMetadataContext(ctx)
-			opts = append(opts, grpc.Header(&mt.Header))
-			opts = append(opts, grpc.Trailer(&mt.Trailer))
-			err = invoker(ctx, method, req, reply, cc, opts...)
-			for _, h := range m.afterHandlers {
-				h(ctx, header, method, req, reply, cc, err, opts...)
+		}()
+		for _, h := range m.beforeInterceptors {
+			if err = h(ctx, header, method, req, cc, opts...); err != nil {
+				return
 			}
-			return err
-		}),
-	)
+		}
+		opts = append(opts, grpc.Header(&mt.Header))
+		opts = append(opts, grpc.Trailer(&mt.Trailer))
+		err = invoker(ctx, method, req, reply, cc, opts...)
+		for _, h := range m.afterHandlers {
+			h(ctx, header, method, req, reply, cc, err, opts...)
+		}
+		return err
+	}, opts...)
+}
+
+// fail report the error of the call not sent, like the ErrCredential, to the interceptors and after handlers
+func (m *Manager) fail(ctx context.Context, header Header, method string, req, reply interface{}, cc *grpc.ClientConn, err error, opts ...grpc.CallOption) error {
+	return m.chainUnary(ctx, method, req, reply, cc, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		for _, h := range m.afterHandlers {
+			h(ctx, header, method, req, reply, cc, err, opts...)
+		}
+		return err
+	}, opts...)
+}
+
+// RegisterUnaryInterceptor the interceptor wrap the before interceptors, invoker and after handlers,
+// the error is the one after the err_* header or status parsed to CustomError
+func (m *Manager) RegisterUnaryInterceptor(i grpc.UnaryClientInterceptor) {
+	if i != nil {
+		m.unaryInterceptors = append(m.unaryInterceptors, i)
+	}
+}
+
+// RegisterStreamInterceptor the stream version of RegisterUnaryInterceptor
+func (m *Manager) RegisterStreamInterceptor(i grpc.StreamClientInterceptor) {
+	if i != nil {
+		m.streamInterceptors = append(m.streamInterceptors, i)
+	}
+}
+
+// chainUnary wrap the invoker with the unary interceptors, the first registered is the outermost
+func (m *Manager) chainUnary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	for i := len(m.unaryInterceptors) - 1; i >= 0; i-- {
+		interceptor, next := m.unaryInterceptors[i], invoker
+		invoker = func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return interceptor(ctx, method, req, reply, cc, next, opts...)
+		}
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// chainStream wrap the streamer with the stream interceptors, the first registered is the outermost
+func (m *Manager) chainStream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	for i := len(m.streamInterceptors) - 1; i >= 0; i-- {
+		interceptor, next := m.streamInterceptors[i], streamer
+		streamer = func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return interceptor(ctx, desc, cc, method, next, opts...)
+		}
+	}
+	return streamer(ctx, desc, cc, method, opts...)
+}
+
+// parseErr build the custom error from the err_* response metadata, nil if not exist
+func (m *Manager) parseErr(md metadata.MD) error {
+	errCode := "1"
+	errStatus := "500"
+	errMessage := ""
+	errMessages := md.Get("err_message")
+	if len(errMessages) > 0 {
+		errMessage = errMessages[0]
+	}
+	if errMessage == "" {
+		return nil
+	}
+	errCodes := md.Get("err_code")
+	if len(errCodes) > 0 {
+		errCode = errCodes[0]
+	}
+	errStatuss := md.Get("err_status")
+	if len(errStatuss) > 0 {
+		errStatus = errStatuss[0]
+	}
+	var err error
+	if m.errBuilder != nil {
+		err = m.errBuilder(errCode, errMessage, errStatus)
+	} else {
+		err = errors.New(errMessage + "[" + errStatus + " " + errCode + "]")
+	}
+	customErr := NewCustomError(err)
+	customErr.code, customErr.status = errCode, errStatus
+	return customErr
+}
+
+// parseStatusErr build the custom error from the status error with the rpc ErrorInfo detail, or return the error as is
+func (m *Manager) parseStatusErr(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	var info *errdetails.ErrorInfo
+	var details []interface{}
+	for _, d := range st.Details() {
+		if ei, ok1 := d.(*errdetails.ErrorInfo); ok1 && ei.GetDomain() == rpcutil.ErrorDomain && info == nil {
+			info = ei
+		} else {
+			details = append(details, d)
+		}
+	}
+	if info == nil {
+		return err
+	}
+	if info.GetReason() == rpcutil.ThrottleReason {
+		retryAfter, _ := strconv.Atoi(info.GetMetadata()["retry_after"])
+		return &RpsError{err: fmt.Errorf("%w, %s", ErrThrottled, st.Message()), retryAfter: time.Duration(retryAfter) * time.Millisecond}
+	}
+	errCode := info.GetMetadata()["code"]
+	errStatus := info.GetMetadata()["status"]
+	if m.errBuilder != nil {
+		err = m.errBuilder(errCode, st.Message(), errStatus)
+	} else {
+		err = errors.New(st.Message() + "[" + errStatus + " " + errCode + "]")
+	}
+	customErr := NewCustomError(err)
+	customErr.code, customErr.status = errCode, errStatus
+	customErr.details = details
+	return customErr
 }
 
 // Release all rpc client
 func (m *Manager) Release() {
+	if m.healthCancel != nil {
+		m.healthCancel()
+	}
 	for _, c := range m.addrMap {
 		for _, cc := range c {
 			for _, ccc := range cc {
@@ -199,21 +501,41 @@ func (m *Manager) Release() {
 }
 
 func (m *Manager) Call(ctx context.Context, from, to, rqId, appid, uid string, cb func(context.Context, *grpc.ClientConn) error) error {
-	toM := Module(to)
-	addr := m.GetRand(toM)
-	if addr == "" {
-		return NewRpsError("no rpc addr")
+	if cb == nil {
+		return NewRpsError("callback is nil")
 	}
-	return m.HostCall(ctx, addr, 1, from, to, rqId, appid, uid, cb)
+	_, err := m.ValCall(ctx, from, to, rqId, appid, uid, func(ctx context.Context, cc *grpc.ClientConn) (interface{}, error) {
+		return nil, cb(ctx, cc)
+	})
+	return err
 }
 
 func (m *Manager) ValCall(ctx context.Context, from, to, rqId, appid, uid string, cb func(context.Context, *grpc.ClientConn) (interface{}, error)) (interface{}, error) {
-	toM := Module(to)
-	addr := m.GetRand(toM)
-	if addr == "" {
-		return nil, NewRpsError("no rpc addr")
+	head := Header{RqId: rqId, From: from, To: to, AppId: appid, UserId: uid, Meta: outgoingMeta(ctx)}
+	info := &callInfo{}
+	ctx = newCallInfoContext(ctx, info)
+	var tried []string
+	if p := m.hedgePolicy(Module(to)); p != nil {
+		val, final, err := m.hedgeCall(ctx, head, info, p, &tried, cb)
+		// the methods not hedged are retried by the RetryPolicy like the modules without the HedgePolicy
+		if final || !m.shouldRetry(ctx, Module(to), info, err) {
+			return val, setAttempts(err, info.attempts)
+		}
+	}
+	for {
+		addr, done, err := m.pick(ctx, head, tried...)
+		if err != nil {
+			return nil, setAttempts(err, info.attempts)
+		}
+		info.attempts++
+		ctx1, local := newAttemptLocalContext(newAttemptContext(ctx, Attempt{N: info.attempts}))
+		val, err := m.HostValCall(ctx1, addr, 1, from, to, rqId, appid, uid, cb)
+		done(err, local.Load())
+		if local.Load() || !m.shouldRetry(ctx, Module(to), info, err) {
+			return val, setAttempts(err, info.attempts)
+		}
+		tried = append(tried, addr)
 	}
-	return m.HostValCall(ctx, addr, 1, from, to, rqId, appid, uid, cb)
 }
 
 func (m *Manager) HostCall(ctx context.Context, addr string, flag int, from, to, rqId, appid, uid string, cb func(context.Context, *grpc.ClientConn) error) error {
@@ -228,7 +550,7 @@ func (m *Manager) HostCall(ctx context.Context, addr string, flag int, from, to,
 	ctx1, cl := context.WithTimeout(newRpcMetadataContext(ctx), m.callTtl)
 	defer cl()
 
-	ctx1 = metadata.AppendToOutgoingContext(ctx1, "app_id", appid, "user_id", uid, "rq_id", rqId, "rq_type", "rpc", "rq_from", from, "rq_to", to)
+	ctx1 = withOutgoingHeader(ctx1, from, to, rqId, appid, uid)
 
 	return cb(ctx1, cc)
 }
@@ -245,11 +567,30 @@ func (m *Manager) HostValCall(ctx context.Context, addr string, flag int, from,
 	ctx1, cl := context.WithTimeout(newRpcMetadataContext(ctx), m.callTtl)
 	defer cl()
 
-	ctx1 = metadata.AppendToOutgoingContext(ctx1, "app_id", appid, "user_id", uid, "rq_id", rqId, "rq_type", "rpc", "rq_from", from, "rq_to", to)
+	ctx1 = withOutgoingHeader(ctx1, from, to, rqId, appid, uid)
 
 	return cb(ctx1, cc)
 }
 
+func withOutgoingHeader(ctx context.Context, from, to, rqId, appid, uid string) context.Context {
+	kv := []string{"app_id", appid, "user_id", uid, "rq_id", rqId, "rq_type", "rpc", "rq_from", from, "rq_to", to, "rq_err_mode", "status"}
+	for k, v := range outgoingMeta(ctx) {
+		kv = append(kv, k, v)
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// outgoingMeta return the meta of the registered keys in ctx
+func outgoingMeta(ctx context.Context) map[string]string {
+	meta := rpcmeta.FromContext(ctx)
+	for k := range meta {
+		if !rpcmeta.Registered(k) {
+			delete(meta, k)
+		}
+	}
+	return meta
+}
+
 func (m *Manager) SetCallTtl(ttl time.Duration) {
 	if ttl < 10 {
 		ttl = time.Second * ttl
@@ -276,7 +617,17 @@ func (m *Manager) IsCustomError(err error) *CustomError {
 	return nil
 }
 
+// IsBreakerOpen return if the call is rejected by the circuit breaker
+func (m *Manager) IsBreakerOpen(err error) bool {
+	return errors.Is(err, ErrBreakerOpen)
+}
+
 func (m *Manager) parseHeader(ctx context.Context) Header {
+	return HeaderFromOutgoingContext(ctx)
+}
+
+// HeaderFromOutgoingContext return the Header in the outgoing metadata of the call ctx
+func HeaderFromOutgoingContext(ctx context.Context) Header {
 	var rqId, rqFrom, rqTo, appId, userId string
 	md, ok := metadata.FromOutgoingContext(ctx)
 	if ok {
@@ -307,6 +658,7 @@ func (m *Manager) parseHeader(ctx context.Context) Header {
 		To:     rqTo,
 		AppId:  appId,
 		UserId: userId,
+		Meta:   rpcmeta.FromMD(md),
 	}
 }
 
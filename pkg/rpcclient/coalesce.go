@@ -0,0 +1,148 @@
+package rpcclient
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// WithCoalesceKey set the coalescing key of the calls in ctx, the concurrent calls of the same method with the same key share one rpc,
+// it takes precedence over the serialized request and enable the coalescing for the call
+func WithCoalesceKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, "RpcCoalesceKey", key)
+}
+
+func getCoalesceKeyContext(ctx context.Context) string {
+	key, _ := ctx.Value("RpcCoalesceKey").(string)
+	return key
+}
+
+// SetCoalesce enable the coalescing of a module, the concurrent calls with the same module, method, app id, user id, authorization
+// and serialized request share one rpc and its result, set a key by WithCoalesceKey for the results depend on the other headers.
+// Only the first caller runs the interceptors, before interceptors and after handlers, the others get its reply, error
+// and the header and trailer in RpcMetadata
+func (m *Manager) SetCoalesce(module Module, enable bool) {
+	m.Lock()
+	defer m.Unlock()
+	if enable {
+		m.coalesceModules[module] = true
+	} else {
+		delete(m.coalesceModules, module)
+	}
+}
+
+// coalesceKey return the key of the call, false if the call is not coalesced, the caller is in the key,
+// so the rpc is not shared by the callers with the different credentials
+func (m *Manager) coalesceKey(ctx context.Context, head Header, authorization, method string, req, reply interface{}) (string, bool) {
+	if _, ok := reply.(proto.Message); !ok {
+		return "", false
+	}
+	prefix := head.To + method + "#" + head.AppId + "#" + head.UserId + "#" + authorization + "#"
+	if key := getCoalesceKeyContext(ctx); key != "" {
+		return prefix + key, true
+	}
+	m.Lock()
+	enabled := m.coalesceModules[Module(head.To)]
+	m.Unlock()
+	if !enabled {
+		return "", false
+	}
+	msg, ok := req.(proto.Message)
+	if !ok {
+		return "", false
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return "", false
+	}
+	return prefix + string(b), true
+}
+
+type coalesceCall struct {
+	done    chan struct{}
+	reply   proto.Message
+	md      *RpcMetadata
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// coalesce run invoke once for the concurrent calls of the key and copy the reply and metadata to each caller,
+// a caller canceled stop waiting, and the rpc is canceled when all the callers canceled.
+// The attempts of the waiters are marked local, the rpc is reported to the breaker of the addr only by the first caller
+func (m *Manager) coalesce(ctx context.Context, key string, reply interface{}, invoke func(ctx context.Context, reply interface{}) error) error {
+	m.coalesceMu.Lock()
+	c, ok := m.coalescing[key]
+	if !ok {
+		// the deadline of the first caller not apply to the others, the rpc has its own call ttl
+		ctx1, cancel := context.WithTimeout(newRpcMetadataContext(detachedContext{ctx}), m.callTtl)
+		c = &coalesceCall{
+			done:   make(chan struct{}),
+			reply:  reply.(proto.Message).ProtoReflect().New().Interface(),
+			md:     getRpcMetadataContext(ctx1),
+			cancel: cancel,
+		}
+		m.coalescing[key] = c
+		go func() {
+			c.err = invoke(ctx1, c.reply)
+			cancel()
+			m.coalesceMu.Lock()
+			if m.coalescing[key] == c {
+				delete(m.coalescing, key)
+			}
+			m.coalesceMu.Unlock()
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	m.coalesceMu.Unlock()
+	if ok {
+		markLocal(ctx)
+	}
+
+	select {
+	case <-c.done:
+		mt := getRpcMetadataContext(ctx)
+		mt.Header, mt.Trailer = c.md.Header.Copy(), c.md.Trailer.Copy()
+		if c.err != nil {
+			return copyErr(c.err)
+		}
+		proto.Reset(reply.(proto.Message))
+		proto.Merge(reply.(proto.Message), c.reply)
+		return nil
+	case <-ctx.Done():
+		m.coalesceMu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			if m.coalescing[key] == c {
+				delete(m.coalescing, key)
+			}
+		}
+		m.coalesceMu.Unlock()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// detachedContext keep the values of the parent without its deadline and cancel, so the shared rpc is not canceled with the first caller
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (c detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,82 @@
+package rpcclient
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// CredentialProvider return the authorization metadata of the call, like "Bearer <jwt>", empty for none,
+// it is called for each attempt, so the token can be refreshed
+type CredentialProvider func(ctx context.Context, head Header) (string, error)
+
+// BearerToken the provider of a static jwt
+func BearerToken(token string) CredentialProvider {
+	return func(context.Context, Header) (string, error) {
+		return "Bearer " + token, nil
+	}
+}
+
+// ApiKey the provider of a static api key
+func ApiKey(key string) CredentialProvider {
+	return func(context.Context, Header) (string, error) {
+		return "ApiKey " + key, nil
+	}
+}
+
+// SetCredentials set the credential provider of a module, nil to use the default
+func (m *Manager) SetCredentials(module Module, p CredentialProvider) {
+	m.Lock()
+	defer m.Unlock()
+	if p == nil {
+		delete(m.credentials, module)
+	} else {
+		m.credentials[module] = p
+	}
+}
+
+// SetDefaultCredentials set the credential provider of the modules without one
+func (m *Manager) SetDefaultCredentials(p CredentialProvider) {
+	m.Lock()
+	defer m.Unlock()
+	m.defaultCredentials = p
+}
+
+// WithCredential set the authorization of the calls with ctx, it takes precedence over the providers,
+// like forwarding the token of the end user
+func WithCredential(ctx context.Context, authorization string) context.Context {
+	return context.WithValue(ctx, "RpcCredential", authorization)
+}
+
+// withCredentials append the authorization to the outgoing metadata and return it, the one already in the outgoing metadata is kept,
+// it is resolved before the call is sent, so a provider failure is the ErrCredential answered locally, passed to the interceptors and after handlers,
+// but not reported to the breaker of the addr nor retried
+func (m *Manager) withCredentials(ctx context.Context, head Header) (context.Context, string, error) {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			return ctx, v[0], nil
+		}
+	}
+	authorization, _ := ctx.Value("RpcCredential").(string)
+	if authorization == "" {
+		m.Lock()
+		p, ok := m.credentials[Module(head.To)]
+		if !ok {
+			p = m.defaultCredentials
+		}
+		m.Unlock()
+		if p == nil {
+			return ctx, "", nil
+		}
+		var err error
+		if authorization, err = p(ctx, head); err != nil {
+			markLocal(ctx)
+			return ctx, "", fmt.Errorf("%w, %s", ErrCredential, err.Error())
+		}
+	}
+	if authorization == "" {
+		return ctx, "", nil
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", authorization), authorization, nil
+}
@@ -0,0 +1,38 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCredentialFailure(t *testing.T) {
+	m := NewManager()
+	m.SetDefaultCredentials(func(context.Context, Header) (string, error) {
+		return "", errors.New("token expired")
+	})
+	var intercepted, handled error
+	m.RegisterUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		intercepted = invoker(ctx, method, req, reply, cc, opts...)
+		return intercepted
+	})
+	m.RegisterAfterHandler(func(ctx context.Context, head Header, method string, req, reply interface{}, cc *grpc.ClientConn, err error, opts ...grpc.CallOption) {
+		handled = err
+	})
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		t.Fatal("the call sent without the credential")
+		return nil
+	}
+	ctx := newRpcMetadataContext(metadata.AppendToOutgoingContext(context.Background(), "rq_to", "user"))
+	err := m.unaryInterceptor(ctx, "/user.User/Get", wrapperspb.String("u1"), &wrapperspb.StringValue{}, nil, invoker)
+	if !errors.Is(err, ErrCredential) {
+		t.Fatalf("want the ErrCredential, got %v", err)
+	}
+	if !errors.Is(intercepted, ErrCredential) || !errors.Is(handled, ErrCredential) {
+		t.Fatalf("want the ErrCredential passed to the interceptor and after handler, got %v and %v", intercepted, handled)
+	}
+}
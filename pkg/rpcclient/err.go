@@ -1,21 +1,83 @@
 package rpcclient
 
-import "errors"
+import (
+	"errors"
+	"time"
+
+	"github.com/obnahsgnaw/rpc/internal/rpcutil"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrNoAddr      = errors.New("no rpc addr")
+	ErrBreakerOpen = errors.New("circuit breaker open")
+	ErrQuorum      = errors.New("broadcast quorum not reached")
+	// ErrThrottled the call throttled by the rate limit of the server, it is returned as the retryable RpsError
+	ErrThrottled = errors.New("rpc throttled")
+	// ErrStreamIdle the stream canceled for no message passed through in the idle ttl, it is returned as the RpsError
+	ErrStreamIdle = errors.New("rpc stream idle timeout")
+	// ErrCredential the credential provider failed, the call is not sent and not retried
+	ErrCredential = errors.New("fetch credential failed")
+)
+
+// IsThrottled return if the error is of a throttled call, the ErrThrottled, or the status not parsed yet like in the after handlers
+// and the rpcserver.ThrottleError
+func IsThrottled(err error) bool {
+	if errors.Is(err, ErrThrottled) {
+		return true
+	}
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.ResourceExhausted {
+		return false
+	}
+	for _, d := range st.Details() {
+		if info, ok1 := d.(*errdetails.ErrorInfo); ok1 && info.GetDomain() == rpcutil.ErrorDomain && info.GetReason() == rpcutil.ThrottleReason {
+			return true
+		}
+	}
+	return false
+}
 
 type RpsError struct {
-	err error
+	err        error
+	attempts   int
+	retryAfter time.Duration
 }
 
 func (e *RpsError) Error() string {
 	return e.err.Error()
 }
 
+func (e *RpsError) Unwrap() error {
+	return e.err
+}
+
+// Attempts return how many attempts were made for the call
+func (e *RpsError) Attempts() int {
+	return e.attempts
+}
+
+// RetryAfter return the wait the server suggested before retry, like for the ErrThrottled
+func (e *RpsError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
 func NewRpsError(msg string) *RpsError {
 	return &RpsError{err: errors.New(msg)}
 }
 
+func newRpsError(err error) *RpsError {
+	return &RpsError{err: err}
+}
+
 type CustomError struct {
-	err error
+	err      error
+	code     string
+	status   string
+	attempts int
+	details  []interface{}
 }
 
 func (e *CustomError) Error() string {
@@ -26,6 +88,52 @@ func (e *CustomError) Raw() error {
 	return e.err
 }
 
+// Code return the err code sent by the server
+func (e *CustomError) Code() string {
+	return e.code
+}
+
+// Status return the http like status code sent by the server
+func (e *CustomError) Status() string {
+	return e.status
+}
+
+// Attempts return how many attempts were made for the call
+func (e *CustomError) Attempts() int {
+	return e.attempts
+}
+
+// Details return the detail protos of the status error, the ErrorInfo carrying the code and status is not included
+func (e *CustomError) Details() []interface{} {
+	return e.details
+}
+
 func NewCustomError(err error) *CustomError {
 	return &CustomError{err: err}
 }
+
+func setAttempts(err error, n int) error {
+	var rpsErr *RpsError
+	if errors.As(err, &rpsErr) {
+		rpsErr.attempts = n
+	}
+	var customErr *CustomError
+	if errors.As(err, &customErr) {
+		customErr.attempts = n
+	}
+	return err
+}
+
+// copyErr return a copy of the RpsError and CustomError shared by the coalesced calls, so setAttempts of a call not change the others
+func copyErr(err error) error {
+	switch e := err.(type) {
+	case *RpsError:
+		c := *e
+		return &c
+	case *CustomError:
+		c := *e
+		return &c
+	default:
+		return err
+	}
+}
@@ -0,0 +1,151 @@
+package rpcclient
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/obnahsgnaw/application/pkg/utils"
+)
+
+// HashKey return the key of a call for the hash balancer
+type HashKey func(ctx context.Context, head Header) string
+
+func HashByUserId(_ context.Context, head Header) string {
+	return head.UserId
+}
+
+func HashByAppId(_ context.Context, head Header) string {
+	return head.AppId
+}
+
+// WithHashKey set the key for the hash balancer, it takes precedence over the HashKey of the balancer
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, "RpcHashKey", key)
+}
+
+func getHashKeyContext(ctx context.Context) string {
+	key, _ := ctx.Value("RpcHashKey").(string)
+	return key
+}
+
+// HashBalancer pick the addr by a consistent hash ring with virtual nodes, the calls with the same key land on the same addr,
+// and only the keys of the added or removed addr move when the addr list changed. The ring is built from all the discovered addrs,
+// the addrs not in the pick list, like the unhealthy, breaker open or retried ones, are skipped while walking the ring
+type HashBalancer struct {
+	sync.Mutex
+	key      HashKey
+	replicas int
+	rings    map[Module]*hashRing
+}
+
+// NewHashBalancer key default HashByUserId, replicas is the virtual node count of each addr, default 100
+func NewHashBalancer(key HashKey, replicas int) *HashBalancer {
+	if key == nil {
+		key = HashByUserId
+	}
+	if replicas <= 0 {
+		replicas = 100
+	}
+	return &HashBalancer{key: key, replicas: replicas, rings: make(map[Module]*hashRing)}
+}
+
+func (b *HashBalancer) Pick(ctx context.Context, head Header, list []Endpoint) string {
+	key := getHashKeyContext(ctx)
+	if key == "" {
+		key = b.key(ctx, head)
+	}
+	if key == "" {
+		return list[utils.RandInt(len(list))].Addr
+	}
+	all := getEndpointsContext(ctx)
+	if len(all) == 0 {
+		all = list
+	}
+	return b.ring(Module(head.To), all).get(hash(key), list)
+}
+
+func (b *HashBalancer) Done(Module, string, error) {}
+
+// ring return the ring of the module, rebuild it when the discovered addr list changed
+func (b *HashBalancer) ring(module Module, list []Endpoint) *hashRing {
+	addrs := make([]string, len(list))
+	for i, e := range list {
+		addrs[i] = e.Addr
+	}
+	sign := strings.Join(addrs, ",")
+	b.Lock()
+	defer b.Unlock()
+	if r, ok := b.rings[module]; ok && r.sign == sign {
+		return r
+	}
+	r := newHashRing(sign, addrs, b.replicas)
+	b.rings[module] = r
+	return r
+}
+
+type hashRing struct {
+	sign   string
+	hashes []uint64
+	addrs  map[uint64]string
+}
+
+func newHashRing(sign string, addrs []string, replicas int) *hashRing {
+	r := &hashRing{sign: sign, addrs: make(map[uint64]string, len(addrs)*replicas)}
+	for _, addr := range addrs {
+		for i := 0; i < replicas; i++ {
+			h := hash(addr + "#" + strconv.Itoa(i))
+			if _, ok := r.addrs[h]; !ok {
+				r.addrs[h] = addr
+				r.hashes = append(r.hashes, h)
+			}
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool {
+		return r.hashes[i] < r.hashes[j]
+	})
+	return r
+}
+
+// get return the first addr of the list clockwise from h
+func (r *hashRing) get(h uint64, list []Endpoint) string {
+	allowed := make(map[string]bool, len(list))
+	for _, e := range list {
+		allowed[e.Addr] = true
+	}
+	i := sort.Search(len(r.hashes), func(i int) bool {
+		return r.hashes[i] >= h
+	})
+	for n := 0; n < len(r.hashes); n++ {
+		if addr := r.addrs[r.hashes[(i+n)%len(r.hashes)]]; allowed[addr] {
+			return addr
+		}
+	}
+	return list[utils.RandInt(len(list))].Addr
+}
+
+// newEndpointsContext pass all the discovered endpoints of the module to the balancer, the list of Pick may exclude some
+func newEndpointsContext(ctx context.Context, all []Endpoint) context.Context {
+	return context.WithValue(ctx, "RpcEndpoints", all)
+}
+
+func getEndpointsContext(ctx context.Context) []Endpoint {
+	all, _ := ctx.Value("RpcEndpoints").([]Endpoint)
+	return all
+}
+
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	// fnv alone spreads the similar keys like addr#i poorly, mix the bits
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
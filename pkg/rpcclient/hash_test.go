@@ -0,0 +1,34 @@
+package rpcclient
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestHashBalancerExcluded(t *testing.T) {
+	b := NewHashBalancer(nil, 0)
+	all := []Endpoint{{Addr: "127.0.0.1:8001"}, {Addr: "127.0.0.1:8002"}, {Addr: "127.0.0.1:8003"}}
+	ctx := newEndpointsContext(context.Background(), all)
+	picked := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		uid := strconv.Itoa(i)
+		picked[uid] = b.Pick(ctx, Header{To: "user", UserId: uid}, all)
+	}
+	ring := b.rings["user"]
+
+	// the call excluding 8002, like a retry after it failed
+	rest := []Endpoint{all[0], all[2]}
+	for uid, addr := range picked {
+		got := b.Pick(ctx, Header{To: "user", UserId: uid}, rest)
+		if got == "127.0.0.1:8002" {
+			t.Fatalf("user %s picked the excluded addr", uid)
+		}
+		if addr != "127.0.0.1:8002" && got != addr {
+			t.Fatalf("user %s moved from %s to %s", uid, addr, got)
+		}
+	}
+	if b.rings["user"] != ring {
+		t.Fatal("the ring rebuilt for the excluded addr")
+	}
+}
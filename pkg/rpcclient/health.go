@@ -0,0 +1,169 @@
+package rpcclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// HealthCheckConfig the active health check of the module addrs by the grpc.health.v1 protocol
+type HealthCheckConfig struct {
+	// Interval default 5s
+	Interval time.Duration
+	// Timeout of each probe, default 1s
+	Timeout time.Duration
+	// Service the service name to check, default empty for the whole server
+	Service string
+	// UnhealthyThreshold the consecutive failures to mark unhealthy, default 2
+	UnhealthyThreshold int
+	// HealthyThreshold the consecutive successes to mark healthy again, default 1
+	HealthyThreshold int
+	// OnChange called when an addr turn healthy or unhealthy
+	OnChange func(module Module, addr string, healthy bool)
+}
+
+type healthState struct {
+	healthy   bool
+	successes int
+	failures  int
+}
+
+func newHealthProbeContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, "RpcHealthProbe", true)
+}
+
+func isHealthProbeContext(ctx context.Context) bool {
+	probe, _ := ctx.Value("RpcHealthProbe").(bool)
+	return probe
+}
+
+// StartHealthCheck probe all the module addrs in background until the ctx done or manager released,
+// the unhealthy addrs are excluded from GetRand and Call
+func (m *Manager) StartHealthCheck(ctx context.Context, cnf *HealthCheckConfig) {
+	if cnf == nil {
+		return
+	}
+	c := *cnf
+	if c.Interval <= 0 {
+		c.Interval = 5 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.UnhealthyThreshold <= 0 {
+		c.UnhealthyThreshold = 2
+	}
+	if c.HealthyThreshold <= 0 {
+		c.HealthyThreshold = 1
+	}
+	m.Lock()
+	if m.healthCancel != nil {
+		m.healthCancel()
+	}
+	ctx, m.healthCancel = context.WithCancel(ctx)
+	m.Unlock()
+	go func() {
+		ticker := time.NewTicker(c.Interval)
+		defer ticker.Stop()
+		for {
+			m.checkHealth(ctx, &c)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (m *Manager) checkHealth(ctx context.Context, cnf *HealthCheckConfig) {
+	var wg sync.WaitGroup
+	for _, module := range m.Modules() {
+		for _, addr := range m.Get(module) {
+			wg.Add(1)
+			go func(module Module, addr string) {
+				defer wg.Done()
+				m.setHealth(module, addr, m.probe(ctx, module, addr, cnf), cnf)
+			}(module, addr)
+		}
+	}
+	wg.Wait()
+}
+
+// probe the addr is healthy when serving, or the server is reachable but has no health service
+func (m *Manager) probe(ctx context.Context, module Module, addr string, cnf *HealthCheckConfig) bool {
+	cc, err := m.GetConn(module, addr, 0)
+	if err != nil {
+		return false
+	}
+	ctx1, cl := context.WithTimeout(newHealthProbeContext(newRpcMetadataContext(ctx)), cnf.Timeout)
+	defer cl()
+	resp, err := grpc_health_v1.NewHealthClient(cc).Check(ctx1, &grpc_health_v1.HealthCheckRequest{Service: cnf.Service})
+	if err != nil {
+		return status.Code(err) == codes.Unimplemented
+	}
+	return resp.GetStatus() == grpc_health_v1.HealthCheckResponse_SERVING
+}
+
+func (m *Manager) setHealth(module Module, addr string, ok bool, cnf *HealthCheckConfig) {
+	m.Lock()
+	if _, exist := m.addrMap[module][addr]; !exist {
+		m.Unlock()
+		return
+	}
+	if _, exist := m.health[module]; !exist {
+		m.health[module] = make(map[string]*healthState)
+	}
+	st, exist := m.health[module][addr]
+	if !exist {
+		st = &healthState{healthy: true}
+		m.health[module][addr] = st
+	}
+	changed := false
+	if ok {
+		st.failures = 0
+		st.successes++
+		if !st.healthy && st.successes >= cnf.HealthyThreshold {
+			st.healthy, changed = true, true
+		}
+	} else {
+		st.successes = 0
+		st.failures++
+		if st.healthy && st.failures >= cnf.UnhealthyThreshold {
+			st.healthy, changed = false, true
+		}
+	}
+	healthy := st.healthy
+	m.Unlock()
+	if changed && cnf.OnChange != nil {
+		cnf.OnChange(module, addr, healthy)
+	}
+}
+
+// healthy return if the addr is not marked unhealthy
+func (m *Manager) healthy(module Module, addr string) bool {
+	m.Lock()
+	defer m.Unlock()
+	if st, ok := m.health[module][addr]; ok {
+		return st.healthy
+	}
+	return true
+}
+
+// HealthState return the health of the module addrs, the addrs not checked yet are healthy
+func (m *Manager) HealthState(module Module) map[string]bool {
+	m.Lock()
+	defer m.Unlock()
+	states := make(map[string]bool)
+	for addr := range m.addrMap[module] {
+		states[addr] = true
+		if st, ok := m.health[module][addr]; ok {
+			states[addr] = st.healthy
+		}
+	}
+	return states
+}
@@ -0,0 +1,254 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// HedgePolicy send the same request of an idempotent method to another addr when the reply is slow, the first success reply is taken
+// and the others are canceled, the hedged calls are not retried by the RetryPolicy, the calls of the other methods are
+type HedgePolicy struct {
+	// Methods the full methods which are idempotent and hedged, like /package.service/method
+	Methods []string
+	// Delay the duration wait for the reply before a hedged attempt, default 0 to use the Percentile
+	Delay time.Duration
+	// Percentile the latency percentile of the module as the delay, default 0.95, no hedge before 10 latencies recorded
+	Percentile float64
+	// MaxAttempts the max attempts include the first one, default 2
+	MaxAttempts int
+	// Budget limit the hedged attempts shared by the calls, nil no limit
+	Budget *HedgeBudget
+}
+
+func (p *HedgePolicy) hedgeable(method string) bool {
+	for _, m := range p.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// HedgeBudget a token bucket, each call put ratio tokens and each hedged attempt take a token,
+// so the hedged attempts are limited to about ratio of the calls
+type HedgeBudget struct {
+	sync.Mutex
+	max    float64
+	ratio  float64
+	tokens float64
+}
+
+func NewHedgeBudget(maxTokens, ratio float64) *HedgeBudget {
+	return &HedgeBudget{max: maxTokens, ratio: ratio, tokens: maxTokens}
+}
+
+func (b *HedgeBudget) call() {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.tokens = math.Min(b.tokens+b.ratio, b.max)
+}
+
+// hedge return if a hedged attempt is allowed
+func (b *HedgeBudget) hedge() bool {
+	if b == nil {
+		return true
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// SetHedgePolicy set the hedge policy of a module, nil to remove
+func (m *Manager) SetHedgePolicy(module Module, p *HedgePolicy) {
+	m.Lock()
+	defer m.Unlock()
+	if p == nil {
+		delete(m.hedgePolicies, module)
+	} else {
+		m.hedgePolicies[module] = p
+	}
+}
+
+func (m *Manager) hedgePolicy(module Module) *HedgePolicy {
+	m.Lock()
+	defer m.Unlock()
+	return m.hedgePolicies[module]
+}
+
+// latencyWindow the latencies of the last success attempts of a module
+type latencyWindow struct {
+	sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+const latencyWindowSize = 100
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.Lock()
+	defer w.Unlock()
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencyWindowSize
+}
+
+// percentile return the p percentile, false before 10 latencies recorded
+func (w *latencyWindow) percentile(p float64) (time.Duration, bool) {
+	w.Lock()
+	samples := append([]time.Duration(nil), w.samples...)
+	w.Unlock()
+	if len(samples) < 10 {
+		return 0, false
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	i := int(math.Ceil(p*float64(len(samples)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return samples[i], true
+}
+
+func (m *Manager) latency(module Module) *latencyWindow {
+	m.Lock()
+	defer m.Unlock()
+	w, ok := m.latencies[module]
+	if !ok {
+		w = &latencyWindow{}
+		m.latencies[module] = w
+	}
+	return w
+}
+
+// hedgeDelay return the delay before a hedged attempt, false for no hedge
+func (m *Manager) hedgeDelay(module Module, p *HedgePolicy) (time.Duration, bool) {
+	if p.Delay > 0 {
+		return p.Delay, true
+	}
+	percentile := p.Percentile
+	if percentile <= 0 || percentile > 1 {
+		percentile = 0.95
+	}
+	return m.latency(module).percentile(percentile)
+}
+
+type attemptResult struct {
+	val   interface{}
+	err   error
+	local bool
+}
+
+// hedgeCall start the first attempt, and a hedged attempt on another addr every delay until a reply received or max attempts reached,
+// an attempt failed before the reply start the next one at once. The replied custom error and the local result like a cache hit
+// are taken as the result like a success.
+// The method is known after the first attempt started, final false if it is not hedgeable and the result of the only attempt
+// is left to the retries, the addr is in tried
+func (m *Manager) hedgeCall(ctx context.Context, head Header, info *callInfo, p *HedgePolicy, tried *[]string, cb func(context.Context, *grpc.ClientConn) (interface{}, error)) (interface{}, bool, error) {
+	module := Module(head.To)
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 2
+	}
+	p.Budget.call()
+	latency := m.latency(module)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan attemptResult, maxAttempts)
+	start := func(hedged bool) error {
+		if hedged && !m.hasUntried(module, *tried) {
+			return ErrNoAddr
+		}
+		addr, done, err := m.pick(ctx, head, *tried...)
+		if err != nil {
+			return err
+		}
+		*tried = append(*tried, addr)
+		info.attempts++
+		ctx1, local := newAttemptLocalContext(newAttemptContext(ctx, Attempt{N: info.attempts, Hedged: hedged}))
+		go func() {
+			startAt := time.Now()
+			val, err := m.HostValCall(ctx1, addr, 1, head.From, head.To, head.RqId, head.AppId, head.UserId, cb)
+			isLocal := local.Load()
+			done(err, isLocal)
+			if err == nil && !isLocal {
+				latency.add(time.Since(startAt))
+			}
+			results <- attemptResult{val: val, err: err, local: isLocal}
+		}()
+		return nil
+	}
+	if err := start(false); err != nil {
+		return nil, true, err
+	}
+	inFlight := 1
+	var hedgeC <-chan time.Time
+	if delay, ok := m.hedgeDelay(module, p); ok {
+		t := time.NewTicker(delay)
+		defer t.Stop()
+		hedgeC = t.C
+	}
+	var lastErr error
+	for inFlight > 0 {
+		select {
+		case r := <-results:
+			inFlight--
+			if !r.local && !p.hedgeable(info.getMethod()) {
+				return r.val, false, r.err
+			}
+			var customErr *CustomError
+			if r.err == nil || r.local || errors.As(r.err, &customErr) {
+				return r.val, true, r.err
+			}
+			lastErr = r.err
+			if inFlight == 0 && info.attempts < maxAttempts && p.hedgeable(info.getMethod()) && p.Budget.hedge() {
+				if start(true) == nil {
+					inFlight++
+				}
+			}
+		case <-hedgeC:
+			if info.attempts < maxAttempts && p.hedgeable(info.getMethod()) && p.Budget.hedge() {
+				if start(true) == nil {
+					inFlight++
+				}
+			}
+		}
+	}
+	return nil, true, lastErr
+}
+
+// hasUntried return if there is a healthy addr not tried and its breaker not open, the hedged attempts only go to another addr
+func (m *Manager) hasUntried(module Module, tried []string) bool {
+	for _, addr := range m.Get(module) {
+		if !m.healthy(module, addr) || !m.getBreaker(module, addr).available() {
+			continue
+		}
+		found := false
+		for _, t := range tried {
+			if t == addr {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+	return false
+}
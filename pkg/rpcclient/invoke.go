@@ -0,0 +1,48 @@
+package rpcclient
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// Invoke call the full method of the module with the header resolved from ctx like CtxValCall, and return the typed response,
+// Req and Resp are the generated message structs, e.g. Invoke[pb.GetReq, pb.GetResp](ctx, m, "user", "/user.User/Get", req),
+// the header is overridden by WithCallOptions of ctx
+func Invoke[Req, Resp any, PReq interface {
+	*Req
+	proto.Message
+}, PResp interface {
+	*Resp
+	proto.Message
+}](ctx context.Context, m *Manager, to, fullMethod string, req PReq, opts ...grpc.CallOption) (PResp, error) {
+	val, err := m.CtxValCall(ctx, to, func(ctx context.Context, cc *grpc.ClientConn) (interface{}, error) {
+		resp := PResp(new(Resp))
+		if err := cc.Invoke(ctx, fullMethod, req, resp, opts...); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.(PResp), nil
+}
+
+// InvokeStub call the stub method of the module like Invoke, the stub is built by newClient,
+// e.g. InvokeStub(ctx, m, "user", pb.NewUserClient, pb.UserClient.Get, req)
+func InvokeStub[C any, Req, Resp proto.Message](ctx context.Context, m *Manager, to string, newClient func(grpc.ClientConnInterface) C, call func(C, context.Context, Req, ...grpc.CallOption) (Resp, error), req Req, opts ...grpc.CallOption) (Resp, error) {
+	val, err := m.CtxValCall(ctx, to, func(ctx context.Context, cc *grpc.ClientConn) (interface{}, error) {
+		resp, err := call(newClient(cc), ctx, req, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+	if err != nil {
+		var zero Resp
+		return zero, err
+	}
+	return val.(Resp), nil
+}
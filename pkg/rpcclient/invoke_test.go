@@ -0,0 +1,60 @@
+package rpcclient
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// stringClient a stub like the generated ones
+type stringClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func newStringClient(cc grpc.ClientConnInterface) *stringClient {
+	return &stringClient{cc: cc}
+}
+
+func (c *stringClient) Get(ctx context.Context, in *wrapperspb.StringValue, opts ...grpc.CallOption) (*wrapperspb.StringValue, error) {
+	out := new(wrapperspb.StringValue)
+	if err := c.cc.Invoke(ctx, "/user.User/Get", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func TestInvoke(t *testing.T) {
+	m := NewManager()
+	m.Add("user", "127.0.0.1:8001")
+	var rqId string
+	var waitForReady bool
+	m.RegisterUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		rqId = HeaderFromOutgoingContext(ctx).RqId
+		for _, opt := range opts {
+			if o, ok := opt.(grpc.FailFastCallOption); ok {
+				waitForReady = !o.FailFast
+			}
+		}
+		reply.(*wrapperspb.StringValue).Value = req.(*wrapperspb.StringValue).Value
+		return nil
+	})
+
+	ctx := WithCallOptions(context.Background(), WithRqId("r1"))
+	resp, err := Invoke[wrapperspb.StringValue, wrapperspb.StringValue](ctx, m, "user", "/user.User/Get", wrapperspb.String("u1"), grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Value != "u1" || rqId != "r1" || !waitForReady {
+		t.Fatalf("want the reply u1 of the call r1 waiting for ready, got %s of %s and %v", resp.Value, rqId, waitForReady)
+	}
+
+	resp, err = InvokeStub(ctx, m, "user", newStringClient, (*stringClient).Get, wrapperspb.String("u2"), grpc.WaitForReady(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Value != "u2" || waitForReady {
+		t.Fatalf("want the reply u2 not waiting for ready, got %s and %v", resp.Value, waitForReady)
+	}
+}
@@ -0,0 +1,98 @@
+package rpcclient
+
+import (
+	"context"
+	"strings"
+
+	"github.com/obnahsgnaw/rpc/pkg/rpcmeta"
+
+	"google.golang.org/grpc"
+)
+
+// HeaderResolver return the header to propagate from the ctx of the ctx calls, such as the inbound header of a handler
+type HeaderResolver func(ctx context.Context) Header
+
+// CallOption override a field of the propagated header
+type CallOption func(head *Header)
+
+func WithRqId(rqId string) CallOption {
+	return func(head *Header) {
+		head.RqId = rqId
+	}
+}
+
+func WithFrom(from string) CallOption {
+	return func(head *Header) {
+		head.From = from
+	}
+}
+
+func WithAppId(appId string) CallOption {
+	return func(head *Header) {
+		head.AppId = appId
+	}
+}
+
+func WithUserId(userId string) CallOption {
+	return func(head *Header) {
+		head.UserId = userId
+	}
+}
+
+// WithMeta set a rpcmeta key, the unregistered keys are not propagated
+func WithMeta(key, val string) CallOption {
+	return func(head *Header) {
+		if head.Meta == nil {
+			head.Meta = make(map[string]string)
+		}
+		head.Meta[strings.ToLower(key)] = val
+	}
+}
+
+// WithCallOptions set the header overrides of the ctx calls with ctx, like the Invoke taking the grpc call options,
+// the options passed to the call are applied after them
+func WithCallOptions(ctx context.Context, opts ...CallOption) context.Context {
+	prev, _ := ctx.Value("RpcCallOptions").([]CallOption)
+	return context.WithValue(ctx, "RpcCallOptions", append(prev[:len(prev):len(prev)], opts...))
+}
+
+// SetHeaderResolver set the resolver of the ctx calls, nil to propagate nothing
+func (m *Manager) SetHeaderResolver(resolver HeaderResolver) {
+	m.headerResolver = resolver
+}
+
+// ctxHeader resolve the header from ctx and apply the options of ctx and the call, the meta default to the rpcmeta of ctx and is set back to it
+func (m *Manager) ctxHeader(ctx context.Context, to string, opts []CallOption) (context.Context, Header) {
+	var head Header
+	if m.headerResolver != nil {
+		head = m.headerResolver(ctx)
+	}
+	if head.Meta == nil {
+		head.Meta = rpcmeta.FromContext(ctx)
+	}
+	ctxOpts, _ := ctx.Value("RpcCallOptions").([]CallOption)
+	for _, opt := range append(ctxOpts[:len(ctxOpts):len(ctxOpts)], opts...) {
+		opt(&head)
+	}
+	head.To = to
+	if len(head.Meta) > 0 {
+		ctx = rpcmeta.NewContext(ctx, head.Meta)
+	}
+	return ctx, head
+}
+
+// CtxCall call the module with the header resolved from ctx, the ctx deadline and cancel carry over to the call
+func (m *Manager) CtxCall(ctx context.Context, to string, cb func(context.Context, *grpc.ClientConn) error, opts ...CallOption) error {
+	ctx, head := m.ctxHeader(ctx, to, opts)
+	return m.Call(ctx, head.From, head.To, head.RqId, head.AppId, head.UserId, cb)
+}
+
+func (m *Manager) CtxValCall(ctx context.Context, to string, cb func(context.Context, *grpc.ClientConn) (interface{}, error), opts ...CallOption) (interface{}, error) {
+	ctx, head := m.ctxHeader(ctx, to, opts)
+	return m.ValCall(ctx, head.From, head.To, head.RqId, head.AppId, head.UserId, cb)
+}
+
+func (m *Manager) CtxStreamCall(ctx context.Context, to string, cb func(context.Context, *grpc.ClientConn) error, opts ...CallOption) error {
+	ctx, head := m.ctxHeader(ctx, to, opts)
+	return m.StreamCall(ctx, head.From, head.To, head.RqId, head.AppId, head.UserId, cb)
+}
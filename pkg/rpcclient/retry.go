@@ -0,0 +1,230 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryPolicy retry the failed call on another addr of the module
+type RetryPolicy struct {
+	// MaxAttempts the max attempts include the first one
+	MaxAttempts int
+	// InitialBackoff the backoff before the first retry, default 50ms
+	InitialBackoff time.Duration
+	// MaxBackoff default 1s
+	MaxBackoff time.Duration
+	// Multiplier the backoff growth of each retry, default 2
+	Multiplier float64
+	// Jitter the random part of the backoff in [0,1], default 0.2
+	Jitter float64
+	// RetryableCodes the grpc codes to retry, default Unavailable, the RpsError is always retryable and the CustomError never
+	RetryableCodes []codes.Code
+	// Budget limit the retries shared by the calls, nil no limit
+	Budget *RetryBudget
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	var customErr *CustomError
+	if errors.As(err, &customErr) {
+		return false
+	}
+	var rpsErr *RpsError
+	if errors.As(err, &rpsErr) {
+		return true
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	retryableCodes := p.RetryableCodes
+	if len(retryableCodes) == 0 {
+		retryableCodes = []codes.Code{codes.Unavailable}
+	}
+	for _, c := range retryableCodes {
+		if st.Code() == c {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff return the backoff before the nth retry
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	initial := p.InitialBackoff
+	if initial <= 0 {
+		initial = 50 * time.Millisecond
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = time.Second
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	jitter := p.Jitter
+	if jitter <= 0 || jitter > 1 {
+		jitter = 0.2
+	}
+	d := math.Min(float64(initial)*math.Pow(multiplier, float64(n-1)), float64(max))
+	return time.Duration(d * (1 - jitter*rand.Float64()))
+}
+
+// RetryBudget a token bucket, each failed call take a token and each success call put back ratio tokens,
+// the retries are allowed only when more than half of the tokens left, so the retries can not amplify an outage
+type RetryBudget struct {
+	sync.Mutex
+	max    float64
+	ratio  float64
+	tokens float64
+}
+
+func NewRetryBudget(maxTokens, ratio float64) *RetryBudget {
+	return &RetryBudget{max: maxTokens, ratio: ratio, tokens: maxTokens}
+}
+
+func (b *RetryBudget) success() {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.tokens = math.Min(b.tokens+b.ratio, b.max)
+}
+
+// failure return if a retry is allowed
+func (b *RetryBudget) failure() bool {
+	if b == nil {
+		return true
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.tokens = math.Max(b.tokens-1, 0)
+	return b.tokens > b.max/2
+}
+
+// SetRetryPolicy set the retry policy of a module, method empty for all the methods of the module, nil to remove
+func (m *Manager) SetRetryPolicy(module Module, method string, p *RetryPolicy) {
+	m.Lock()
+	defer m.Unlock()
+	key := module.String() + method
+	if p == nil {
+		delete(m.retryPolicies, key)
+	} else {
+		m.retryPolicies[key] = p
+	}
+}
+
+func (m *Manager) retryPolicy(module Module, method string) *RetryPolicy {
+	m.Lock()
+	defer m.Unlock()
+	if p, ok := m.retryPolicies[module.String()+method]; ok {
+		return p
+	}
+	if p, ok := m.retryPolicies[module.String()]; ok {
+		return p
+	}
+	return nil
+}
+
+// callInfo the state of a call shared by all the attempts
+type callInfo struct {
+	sync.Mutex
+	method   string
+	attempts int
+}
+
+// setMethod record the method invoked by the first attempt
+func (i *callInfo) setMethod(method string) {
+	i.Lock()
+	defer i.Unlock()
+	if i.method == "" {
+		i.method = method
+	}
+}
+
+func (i *callInfo) getMethod() string {
+	i.Lock()
+	defer i.Unlock()
+	return i.method
+}
+
+// Attempt the attempt of a call, N start from 1, Hedged for the hedged attempts
+type Attempt struct {
+	N      int
+	Hedged bool
+}
+
+func newAttemptContext(ctx context.Context, attempt Attempt) context.Context {
+	return context.WithValue(ctx, "RpcAttempt", attempt)
+}
+
+// AttemptFromContext return the attempt of the call in the interceptors and handlers
+func AttemptFromContext(ctx context.Context) (Attempt, bool) {
+	attempt, ok := ctx.Value("RpcAttempt").(Attempt)
+	return attempt, ok
+}
+
+// attemptLocal mark the attempt answered without the addr, like a cache hit, its result is not reported to the breaker,
+// balancer, latencies and retry budget of the addr
+type attemptLocal struct {
+	atomic.Bool
+}
+
+func newAttemptLocalContext(ctx context.Context) (context.Context, *attemptLocal) {
+	l := &attemptLocal{}
+	return context.WithValue(ctx, "RpcAttemptLocal", l), l
+}
+
+// markLocal mark the attempt of ctx answered locally
+func markLocal(ctx context.Context) {
+	if l, ok := ctx.Value("RpcAttemptLocal").(*attemptLocal); ok {
+		l.Store(true)
+	}
+}
+
+func newCallInfoContext(ctx context.Context, info *callInfo) context.Context {
+	return context.WithValue(ctx, "RpcCallInfo", info)
+}
+
+func getCallInfoContext(ctx context.Context) *callInfo {
+	info, _ := ctx.Value("RpcCallInfo").(*callInfo)
+	return info
+}
+
+// shouldRetry report the policy and budget allow another attempt, and wait the backoff
+func (m *Manager) shouldRetry(ctx context.Context, module Module, info *callInfo, err error) bool {
+	p := m.retryPolicy(module, info.getMethod())
+	if p == nil {
+		return false
+	}
+	if err == nil {
+		p.Budget.success()
+		return false
+	}
+	if !p.retryable(err) || !p.Budget.failure() || info.attempts >= p.MaxAttempts {
+		return false
+	}
+	backoff := p.backoff(info.attempts)
+	// wait at least the retry after of the throttled call
+	var rpsErr *RpsError
+	if errors.As(err, &rpsErr) && rpsErr.retryAfter > backoff {
+		backoff = rpsErr.retryAfter
+	}
+	t := time.NewTimer(backoff)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
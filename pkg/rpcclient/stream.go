@@ -0,0 +1,198 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+type StreamBeforeInterceptor func(ctx context.Context, head Header, desc *grpc.StreamDesc, method string, cc *grpc.ClientConn, opts ...grpc.CallOption) error
+
+type StreamAfterHandler func(ctx context.Context, head Header, desc *grpc.StreamDesc, method string, cc *grpc.ClientConn, err error)
+
+// StreamMsgHandler called after each message received(recv=true) or sent(recv=false) on a stream
+type StreamMsgHandler func(ctx context.Context, head Header, method string, msg interface{}, recv bool, err error)
+
+// idleTimer cancel the stream context when no message passed through in ttl
+type idleTimer struct {
+	ttl     time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func newIdleTimer(ttl time.Duration, cancel context.CancelFunc) *idleTimer {
+	t := &idleTimer{ttl: ttl}
+	t.timer = time.AfterFunc(ttl, func() {
+		t.expired.Store(true)
+		cancel()
+	})
+	return t
+}
+
+// wrap return the ErrStreamIdle RpsError for the error of the stream canceled by the timer, so it is not taken as the caller cancel
+func (t *idleTimer) wrap(err error) error {
+	if t == nil || err == nil || err == io.EOF || !t.expired.Load() || errors.Is(err, ErrStreamIdle) {
+		return err
+	}
+	return newRpsError(fmt.Errorf("%w, %s", ErrStreamIdle, err.Error()))
+}
+
+func (t *idleTimer) touch() {
+	if t != nil {
+		t.timer.Reset(t.ttl)
+	}
+}
+
+func (t *idleTimer) stop() {
+	t.timer.Stop()
+}
+
+func newIdleTimerContext(ctx context.Context, t *idleTimer) context.Context {
+	return context.WithValue(ctx, "RpcStreamIdle", t)
+}
+
+func getIdleTimerContext(ctx context.Context) *idleTimer {
+	t, _ := ctx.Value("RpcStreamIdle").(*idleTimer)
+	return t
+}
+
+func (m *Manager) RegisterStreamBeforeInterceptor(interceptor StreamBeforeInterceptor) {
+	m.streamBefores = append(m.streamBefores, interceptor)
+}
+
+func (m *Manager) RegisterStreamAfterHandler(h StreamAfterHandler) {
+	m.streamAfters = append(m.streamAfters, h)
+}
+
+func (m *Manager) RegisterStreamMsgHandler(h StreamMsgHandler) {
+	m.streamMsgHandlers = append(m.streamMsgHandlers, h)
+}
+
+// SetStreamIdleTtl set the max duration a stream can stay without any message
+func (m *Manager) SetStreamIdleTtl(ttl time.Duration) {
+	if ttl < 10 {
+		ttl = time.Second * ttl
+	}
+	m.streamIdleTtl = ttl
+}
+
+func (m *Manager) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (cs grpc.ClientStream, err error) {
+	header := m.parseHeader(ctx)
+	idle := getIdleTimerContext(ctx)
+	return m.chainStream(ctx, desc, cc, method, func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (cs grpc.ClientStream, err error) {
+		for _, h := range m.streamBefores {
+			if err = h(ctx, header, desc, method, cc, opts...); err != nil {
+				return
+			}
+		}
+		if ctx, _, err = m.withCredentials(ctx, header); err == nil {
+			cs, err = streamer(ctx, desc, cc, method, opts...)
+		}
+		if err != nil {
+			for _, h := range m.streamAfters {
+				h(ctx, header, desc, method, cc, err)
+			}
+			return
+		}
+		return &clientStream{ClientStream: cs, m: m, head: header, desc: desc, method: method, cc: cc, idle: idle}, nil
+	}, opts...)
+}
+
+func (m *Manager) StreamCall(ctx context.Context, from, to, rqId, appid, uid string, cb func(context.Context, *grpc.ClientConn) error) error {
+	head := Header{RqId: rqId, From: from, To: to, AppId: appid, UserId: uid, Meta: outgoingMeta(ctx)}
+	addr, done, err := m.pick(ctx, head)
+	if err != nil {
+		return err
+	}
+	ctx, local := newAttemptLocalContext(ctx)
+	err = m.HostStreamCall(ctx, addr, 1, from, to, rqId, appid, uid, cb)
+	done(err, local.Load())
+	return err
+}
+
+// HostStreamCall the stream is canceled after idle ttl without any message instead of a total deadline,
+// the stream errors after then are the RpsError of ErrStreamIdle, counted as the failure of the addr
+func (m *Manager) HostStreamCall(ctx context.Context, addr string, flag int, from, to, rqId, appid, uid string, cb func(context.Context, *grpc.ClientConn) error) error {
+	cc, err := m.GetConn(Module(to), addr, flag)
+	if err != nil {
+		return NewRpsError("fetch client failed")
+	}
+
+	if cb == nil {
+		return NewRpsError("callback is nil")
+	}
+	ctx1, cl := context.WithCancel(ctx)
+	defer cl()
+	idle := newIdleTimer(m.streamIdleTtl, cl)
+	defer idle.stop()
+
+	ctx1 = withOutgoingHeader(newIdleTimerContext(ctx1, idle), from, to, rqId, appid, uid)
+
+	return idle.wrap(cb(ctx1, cc))
+}
+
+// clientStream wrap the grpc.ClientStream to dispatch the message handlers and parse the err_* metadata
+type clientStream struct {
+	grpc.ClientStream
+	m      *Manager
+	head   Header
+	desc   *grpc.StreamDesc
+	method string
+	cc     *grpc.ClientConn
+	idle   *idleTimer
+	once   sync.Once
+}
+
+func (cs *clientStream) SendMsg(msg interface{}) error {
+	cs.idle.touch()
+	err := cs.idle.wrap(cs.ClientStream.SendMsg(msg))
+	for _, h := range cs.m.streamMsgHandlers {
+		h(cs.Context(), cs.head, cs.method, msg, false, err)
+	}
+	if err != nil && err != io.EOF {
+		cs.finish(err)
+	}
+	return err
+}
+
+func (cs *clientStream) RecvMsg(msg interface{}) error {
+	err := cs.ClientStream.RecvMsg(msg)
+	cs.idle.touch()
+	if err != nil {
+		// the stream is ended, the header and trailer are both available now
+		if hd, err1 := cs.Header(); err1 == nil {
+			if err1 = cs.m.parseErr(hd); err1 != nil {
+				err = err1
+			}
+		}
+		if err1 := cs.m.parseErr(cs.Trailer()); err1 != nil {
+			err = err1
+		}
+		if err != io.EOF {
+			err = cs.idle.wrap(cs.m.parseStatusErr(err))
+		}
+	}
+	for _, h := range cs.m.streamMsgHandlers {
+		h(cs.Context(), cs.head, cs.method, msg, true, err)
+	}
+	if err == io.EOF || (err == nil && !cs.desc.ServerStreams) {
+		cs.finish(nil)
+	} else if err != nil {
+		cs.finish(err)
+	}
+	return err
+}
+
+func (cs *clientStream) finish(err error) {
+	cs.once.Do(func() {
+		for _, h := range cs.m.streamAfters {
+			h(cs.Context(), cs.head, cs.desc, cs.method, cs.cc, err)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package rpcclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+func TestStreamIdleTimeout(t *testing.T) {
+	m := NewManager()
+	m.Add("user", "127.0.0.1:8001")
+	m.SetStreamIdleTtl(20 * time.Millisecond)
+	m.SetBreaker("user", &BreakerConfig{MinRequests: 1})
+
+	// the stalled backend never sends a message
+	err := m.StreamCall(context.Background(), "order", "user", "r1", "", "", func(ctx context.Context, cc *grpc.ClientConn) error {
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	})
+	var rpsErr *RpsError
+	if !errors.Is(err, ErrStreamIdle) || !errors.As(err, &rpsErr) {
+		t.Fatalf("want the RpsError of ErrStreamIdle, got %v", err)
+	}
+	if state := m.BreakerState("user")["127.0.0.1:8001"]; state != BreakerOpen {
+		t.Fatalf("want the breaker opened by the idle timeout, got %s", state)
+	}
+}
+
+func TestStreamCallerCancel(t *testing.T) {
+	m := NewManager()
+	m.Add("user", "127.0.0.1:8001")
+	m.SetStreamIdleTtl(time.Minute)
+	m.SetBreaker("user", &BreakerConfig{MinRequests: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := m.StreamCall(ctx, "order", "user", "r1", "", "", func(ctx context.Context, cc *grpc.ClientConn) error {
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	})
+	if errors.Is(err, ErrStreamIdle) {
+		t.Fatalf("the caller cancel taken as the idle timeout, %v", err)
+	}
+	if state := m.BreakerState("user")["127.0.0.1:8001"]; state != BreakerClosed {
+		t.Fatalf("want the breaker closed on the caller cancel, got %s", state)
+	}
+}
@@ -0,0 +1,35 @@
+package rpcclient
+
+import (
+	"github.com/obnahsgnaw/rpc/pkg/rpctls"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// SetTLS dial the servers with TLS, and send the client cert for the mutual TLS if the CertFile set,
+// call it before the calls, the connected clients are not affected
+func (m *Manager) SetTLS(cnf *rpctls.Config) error {
+	creds, err := cnf.ClientCredentials()
+	if err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.creds = creds
+	m.authority = cnf.Authority
+	return nil
+}
+
+// dialOptions the transport options of newClient, called with the lock held
+func (m *Manager) dialOptions() []grpc.DialOption {
+	var creds credentials.TransportCredentials = insecure.NewCredentials()
+	if m.creds != nil {
+		creds = m.creds
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if m.authority != "" {
+		opts = append(opts, grpc.WithAuthority(m.authority))
+	}
+	return opts
+}
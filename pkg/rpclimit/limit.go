@@ -0,0 +1,253 @@
+package rpclimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/obnahsgnaw/rpc/internal/rpcutil"
+	"github.com/obnahsgnaw/rpc/pkg/rpcserver"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// the dimensions of the bucket key
+const (
+	ByFrom   = "from"
+	ByAppId  = "app_id"
+	ByUserId = "user_id"
+	ByMethod = "method"
+)
+
+// Rule a token bucket limit of the matched calls, like:
+//
+//	rules:
+//	  - by: [from]
+//	    rate: 100
+//	    burst: 200
+//	  - method: /user.User/Search
+//	    by: [user_id]
+//	    rate: 5
+type Rule struct {
+	// From, AppId, UserId and Method the calls the rule applies to, empty for all, the Method can be /package.Service/*
+	From   string `json:"from" yaml:"from"`
+	AppId  string `json:"app_id" yaml:"app_id"`
+	UserId string `json:"user_id" yaml:"user_id"`
+	Method string `json:"method" yaml:"method"`
+	// By each combination of the dimensions has its own bucket, empty for a bucket shared by all the matched calls
+	By []string `json:"by" yaml:"by"`
+	// Rate the tokens per second, Burst the bucket size, default the rate
+	Rate  float64 `json:"rate" yaml:"rate"`
+	Burst int     `json:"burst" yaml:"burst"`
+}
+
+// Config the calls must be allowed by all the rules they match
+type Config struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+func (r *Rule) match(head rpcserver.Header, fullMethod string) bool {
+	if r.From != "" && r.From != head.From || r.AppId != "" && r.AppId != head.AppId || r.UserId != "" && r.UserId != head.UserId {
+		return false
+	}
+	if r.Method == "" || r.Method == fullMethod {
+		return true
+	}
+	return strings.HasSuffix(r.Method, "/*") && strings.HasPrefix(fullMethod, r.Method[:len(r.Method)-1])
+}
+
+func (r *Rule) key(head rpcserver.Header, fullMethod string) string {
+	parts := make([]string, 0, len(r.By))
+	for _, by := range r.By {
+		switch by {
+		case ByFrom:
+			parts = append(parts, head.From)
+		case ByAppId:
+			parts = append(parts, head.AppId)
+		case ByUserId:
+			parts = append(parts, head.UserId)
+		case ByMethod:
+			parts = append(parts, fullMethod)
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+func (r *Rule) burst() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return math.Max(r.Rate, 1)
+}
+
+func (r *Rule) validate() error {
+	if r.Rate <= 0 {
+		return errors.New("rpc limit: rate must be positive")
+	}
+	for _, by := range r.By {
+		if by != ByFrom && by != ByAppId && by != ByUserId && by != ByMethod {
+			return errors.New("rpc limit: invalid by " + by)
+		}
+	}
+	return nil
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill the bucket to now, return the wait for the next token if empty
+func (b *bucket) refill(now time.Time, rate, burst float64) time.Duration {
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// Limiter the token bucket rate limit of the served rpc
+type Limiter struct {
+	sync.Mutex
+	cnf      *Config
+	buckets  []map[string]*bucket
+	prunedAt time.Time
+	loader   *rpcutil.FileLoader
+	logger   *zap.Logger
+}
+
+// New Limiter with the rules, change them by SetConfig
+func New(cnf *Config, l *zap.Logger) (*Limiter, error) {
+	lm := &Limiter{logger: l}
+	if err := lm.SetConfig(cnf); err != nil {
+		return nil, err
+	}
+	return lm, nil
+}
+
+// Load the rules from the yaml or json(by the .json ext) file, the file is checked for modification each interval on the calls, default 10s
+func Load(file string, interval time.Duration, l *zap.Logger) (*Limiter, error) {
+	lm := &Limiter{logger: l}
+	loader, err := rpcutil.NewFileLoader("rpc limit", file, interval, lm.apply, l)
+	if err != nil {
+		return nil, err
+	}
+	lm.loader = loader
+	return lm, nil
+}
+
+// SetConfig replace the rules, the buckets are reset
+func (lm *Limiter) SetConfig(cnf *Config) error {
+	if cnf == nil {
+		cnf = &Config{}
+	}
+	for i := range cnf.Rules {
+		if err := cnf.Rules[i].validate(); err != nil {
+			return err
+		}
+	}
+	lm.Lock()
+	defer lm.Unlock()
+	lm.set(cnf)
+	return nil
+}
+
+func (lm *Limiter) set(cnf *Config) {
+	lm.cnf = cnf
+	lm.buckets = make([]map[string]*bucket, len(cnf.Rules))
+	for i := range lm.buckets {
+		lm.buckets[i] = make(map[string]*bucket)
+	}
+}
+
+// Allow take a token of each rule matched only if all of them have one, so a denied call not drain the other buckets,
+// return the longest wait for the next token of the rules denied
+func (lm *Limiter) Allow(head rpcserver.Header, fullMethod string) (bool, time.Duration) {
+	if lm.loader != nil {
+		lm.loader.Refresh()
+	}
+	lm.Lock()
+	defer lm.Unlock()
+	now := time.Now()
+	lm.prune(now)
+	var matched []*bucket
+	var wait time.Duration
+	for i := range lm.cnf.Rules {
+		r := &lm.cnf.Rules[i]
+		if !r.match(head, fullMethod) {
+			continue
+		}
+		burst := r.burst()
+		key := r.key(head, fullMethod)
+		b, ok := lm.buckets[i][key]
+		if !ok {
+			b = &bucket{tokens: burst, last: now}
+			lm.buckets[i][key] = b
+		}
+		if w := b.refill(now, r.Rate, burst); w > wait {
+			wait = w
+		}
+		matched = append(matched, b)
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, b := range matched {
+		b.tokens--
+	}
+	return true, 0
+}
+
+// prune drop the buckets refilled to full each minute, so the buckets of the gone callers are not kept
+func (lm *Limiter) prune(now time.Time) {
+	if now.Sub(lm.prunedAt) < time.Minute {
+		return
+	}
+	lm.prunedAt = now
+	for i, buckets := range lm.buckets {
+		r := &lm.cnf.Rules[i]
+		burst := r.burst()
+		for key, b := range buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*r.Rate >= burst {
+				delete(buckets, key)
+			}
+		}
+	}
+}
+
+// BeforeInterceptor the rpcserver.BeforeInterceptor enforcing the limits
+func (lm *Limiter) BeforeInterceptor() rpcserver.BeforeInterceptor {
+	return func(ctx context.Context, head rpcserver.Header, req interface{}, info *grpc.UnaryServerInfo) error {
+		return lm.check(head, info.FullMethod)
+	}
+}
+
+// StreamBeforeInterceptor the rpcserver.StreamBeforeInterceptor enforcing the limits, a stream take a token when opened
+func (lm *Limiter) StreamBeforeInterceptor() rpcserver.StreamBeforeInterceptor {
+	return func(ctx context.Context, head rpcserver.Header, info *grpc.StreamServerInfo) error {
+		return lm.check(head, info.FullMethod)
+	}
+}
+
+func (lm *Limiter) check(head rpcserver.Header, fullMethod string) error {
+	if ok, wait := lm.Allow(head, fullMethod); !ok {
+		if lm.logger != nil {
+			lm.logger.Debug("rpc throttled", zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.RqId), zap.String("app_id", head.AppId), zap.String("user_id", head.UserId), zap.String("method", fullMethod), zap.Duration("retry_after", wait))
+		}
+		return rpcserver.NewThrottleError("too many requests, retry after "+wait.Round(time.Millisecond).String(), wait)
+	}
+	return nil
+}
+
+// apply take the rules decoded from the file
+func (lm *Limiter) apply(decode func(v interface{}) error) error {
+	cnf := &Config{}
+	if err := decode(cnf); err != nil {
+		return err
+	}
+	return lm.SetConfig(cnf)
+}
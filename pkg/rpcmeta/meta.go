@@ -0,0 +1,174 @@
+package rpcmeta
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// the built-in propagated keys
+const (
+	TenantIdKey      = "tenant_id"
+	LocaleKey        = "locale"
+	DeviceIdKey      = "device_id"
+	ClientVersionKey = "client_version"
+)
+
+// the keys of the fixed header fields and the transport, they can not be registered
+var reserved = map[string]bool{
+	"rq_id":       true,
+	"rq_from":     true,
+	"rq_to":       true,
+	"rq_type":     true,
+	"rq_err_mode": true,
+	"rq_ts":       true,
+	"rq_nonce":    true,
+	"rq_sig":      true,
+	"app_id":      true,
+	"user_id":     true,
+}
+
+var registry = struct {
+	sync.RWMutex
+	keys map[string]bool
+}{keys: map[string]bool{
+	TenantIdKey:      true,
+	LocaleKey:        true,
+	DeviceIdKey:      true,
+	ClientVersionKey: true,
+}}
+
+// Register add the keys propagated through the call metadata, the keys are lowercased,
+// the reserved, grpc- prefixed, -bin suffixed and invalid keys are rejected
+func Register(keys ...string) error {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, key := range keys {
+		key = strings.ToLower(key)
+		if err := validKey(key); err != nil {
+			return err
+		}
+		registry.keys[key] = true
+	}
+	return nil
+}
+
+// Keys return the registered keys sorted
+func Keys() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	keys := make([]string, 0, len(registry.keys))
+	for key := range registry.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Registered return if the key is propagated
+func Registered(key string) bool {
+	registry.RLock()
+	defer registry.RUnlock()
+	return registry.keys[strings.ToLower(key)]
+}
+
+func validKey(key string) error {
+	if key == "" {
+		return errors.New("rpcmeta: empty key")
+	}
+	if reserved[key] || strings.HasPrefix(key, "grpc-") || strings.HasSuffix(key, "-bin") {
+		return errors.New("rpcmeta: key " + key + " is reserved")
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.') {
+			return errors.New("rpcmeta: key " + key + " has invalid char")
+		}
+	}
+	return nil
+}
+
+// FromMD return the values of the registered keys in the metadata, nil if none
+func FromMD(md map[string][]string) map[string]string {
+	var meta map[string]string
+	for _, key := range Keys() {
+		if values := md[key]; len(values) > 0 {
+			if meta == nil {
+				meta = make(map[string]string)
+			}
+			meta[key] = values[0]
+		}
+	}
+	return meta
+}
+
+// NewContext return the ctx with the meta, it replaces the meta in ctx
+func NewContext(ctx context.Context, meta map[string]string) context.Context {
+	m := make(map[string]string, len(meta))
+	for k, v := range meta {
+		m[strings.ToLower(k)] = v
+	}
+	return context.WithValue(ctx, "RpcMeta", m)
+}
+
+// FromContext return a copy of the meta in ctx, nil if none
+func FromContext(ctx context.Context) map[string]string {
+	meta, _ := ctx.Value("RpcMeta").(map[string]string)
+	if len(meta) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(meta))
+	for k, v := range meta {
+		m[k] = v
+	}
+	return m
+}
+
+// With return the ctx with the key set to val, the unregistered keys are kept in ctx but not propagated
+func With(ctx context.Context, key, val string) context.Context {
+	meta := FromContext(ctx)
+	if meta == nil {
+		meta = make(map[string]string)
+	}
+	meta[strings.ToLower(key)] = val
+	return context.WithValue(ctx, "RpcMeta", meta)
+}
+
+// Get return the value of the key in ctx
+func Get(ctx context.Context, key string) string {
+	meta, _ := ctx.Value("RpcMeta").(map[string]string)
+	return meta[strings.ToLower(key)]
+}
+
+func WithTenantId(ctx context.Context, tenantId string) context.Context {
+	return With(ctx, TenantIdKey, tenantId)
+}
+
+func TenantId(ctx context.Context) string {
+	return Get(ctx, TenantIdKey)
+}
+
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return With(ctx, LocaleKey, locale)
+}
+
+func Locale(ctx context.Context) string {
+	return Get(ctx, LocaleKey)
+}
+
+func WithDeviceId(ctx context.Context, deviceId string) context.Context {
+	return With(ctx, DeviceIdKey, deviceId)
+}
+
+func DeviceId(ctx context.Context) string {
+	return Get(ctx, DeviceIdKey)
+}
+
+func WithClientVersion(ctx context.Context, version string) context.Context {
+	return With(ctx, ClientVersionKey, version)
+}
+
+func ClientVersion(ctx context.Context) string {
+	return Get(ctx, ClientVersionKey)
+}
@@ -0,0 +1,236 @@
+package rpcmetrics
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/obnahsgnaw/rpc/internal/rpcutil"
+	"github.com/obnahsgnaw/rpc/pkg/rpcclient"
+	"github.com/obnahsgnaw/rpc/pkg/rpcserver"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Metrics the prometheus metrics of the server and client rpc
+type Metrics struct {
+	serverHandled  *prometheus.CounterVec
+	serverLatency  *prometheus.HistogramVec
+	serverInFlight *prometheus.GaugeVec
+	serverMsgSize  *prometheus.HistogramVec
+	clientHandled  *prometheus.CounterVec
+	clientLatency  *prometheus.HistogramVec
+	clientInFlight *prometheus.GaugeVec
+	clientMsgSize  *prometheus.HistogramVec
+	reg            prometheus.Registerer
+	namespace      string
+}
+
+// New create the metrics and register them to reg, reg nil for the prometheus.DefaultRegisterer
+func New(reg prometheus.Registerer, namespace string) (*Metrics, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	m := &Metrics{
+		reg:       reg,
+		namespace: namespace,
+		serverHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rpc_server_handled_total",
+			Help:      "Total number of rpc handled on the server, by result code.",
+		}, []string{"service", "method", "from", "to", "code"}),
+		serverLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "rpc_server_handling_seconds",
+			Help:      "Latency of rpc handled on the server.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"service", "method", "from", "to"}),
+		serverInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "rpc_server_in_flight",
+			Help:      "Number of rpc in handling on the server.",
+		}, []string{"service", "method"}),
+		serverMsgSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "rpc_server_msg_size_bytes",
+			Help:      "Size of messages received and sent on the server.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+		}, []string{"service", "method", "direction"}),
+		clientHandled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rpc_client_handled_total",
+			Help:      "Total number of rpc completed by the client, by result code.",
+		}, []string{"service", "method", "from", "to", "code"}),
+		clientLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "rpc_client_handling_seconds",
+			Help:      "Latency of rpc completed by the client.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"service", "method", "from", "to"}),
+		clientInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "rpc_client_in_flight",
+			Help:      "Number of rpc in calling by the client.",
+		}, []string{"service", "method"}),
+		clientMsgSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "rpc_client_msg_size_bytes",
+			Help:      "Size of messages sent and received by the client.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+		}, []string{"service", "method", "direction"}),
+	}
+	for _, c := range []prometheus.Collector{
+		m.serverHandled, m.serverLatency, m.serverInFlight, m.serverMsgSize,
+		m.clientHandled, m.clientLatency, m.clientInFlight, m.clientMsgSize,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// WatchManager register the addr pool size of each module of the manager
+func (m *Metrics) WatchManager(manager *rpcclient.Manager) error {
+	return m.reg.Register(newPoolCollector(m.namespace, manager))
+}
+
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		service, method := rpcutil.SplitMethod(info.FullMethod)
+		head, _ := rpcserver.HeaderFromContext(ctx)
+		inFlight := m.serverInFlight.WithLabelValues(service, method)
+		inFlight.Inc()
+		defer inFlight.Dec()
+		m.observeSize(m.serverMsgSize, service, method, "recv", req)
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.serverLatency.WithLabelValues(service, method, head.From, head.To).Observe(time.Since(start).Seconds())
+		m.serverHandled.WithLabelValues(service, method, head.From, head.To, status.Code(err).String()).Inc()
+		if err == nil {
+			m.observeSize(m.serverMsgSize, service, method, "sent", resp)
+		}
+		return resp, err
+	}
+}
+
+func (m *Metrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		service, method := rpcutil.SplitMethod(info.FullMethod)
+		head, _ := rpcserver.HeaderFromContext(ss.Context())
+		inFlight := m.serverInFlight.WithLabelValues(service, method)
+		inFlight.Inc()
+		defer inFlight.Dec()
+		start := time.Now()
+		err := handler(srv, &serverStream{ServerStream: ss, m: m, service: service, method: method})
+		m.serverLatency.WithLabelValues(service, method, head.From, head.To).Observe(time.Since(start).Seconds())
+		m.serverHandled.WithLabelValues(service, method, head.From, head.To, status.Code(err).String()).Inc()
+		return err
+	}
+}
+
+func (m *Metrics) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, fullMethod string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		service, method := rpcutil.SplitMethod(fullMethod)
+		head := rpcclient.HeaderFromOutgoingContext(ctx)
+		inFlight := m.clientInFlight.WithLabelValues(service, method)
+		inFlight.Inc()
+		defer inFlight.Dec()
+		m.observeSize(m.clientMsgSize, service, method, "sent", req)
+		start := time.Now()
+		err := invoker(ctx, fullMethod, req, reply, cc, opts...)
+		m.clientLatency.WithLabelValues(service, method, head.From, head.To).Observe(time.Since(start).Seconds())
+		m.clientHandled.WithLabelValues(service, method, head.From, head.To, status.Code(err).String()).Inc()
+		if err == nil {
+			m.observeSize(m.clientMsgSize, service, method, "recv", reply)
+		}
+		return err
+	}
+}
+
+func (m *Metrics) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, fullMethod string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		service, method := rpcutil.SplitMethod(fullMethod)
+		head := rpcclient.HeaderFromOutgoingContext(ctx)
+		inFlight := m.clientInFlight.WithLabelValues(service, method)
+		inFlight.Inc()
+		start := time.Now()
+		var once sync.Once
+		done := func(err error) {
+			once.Do(func() {
+				inFlight.Dec()
+				m.clientLatency.WithLabelValues(service, method, head.From, head.To).Observe(time.Since(start).Seconds())
+				m.clientHandled.WithLabelValues(service, method, head.From, head.To, status.Code(err).String()).Inc()
+			})
+		}
+		cs, err := streamer(ctx, desc, cc, fullMethod, opts...)
+		if err != nil {
+			done(err)
+			return nil, err
+		}
+		rpcutil.FinishOnDone(ctx, done)
+		return &clientStream{ClientStream: cs, m: m, desc: desc, service: service, method: method, done: done}, nil
+	}
+}
+
+func (m *Metrics) observeSize(h *prometheus.HistogramVec, service, method, direction string, msg interface{}) {
+	if pm, ok := msg.(proto.Message); ok {
+		h.WithLabelValues(service, method, direction).Observe(float64(proto.Size(pm)))
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	m       *Metrics
+	service string
+	method  string
+}
+
+func (ss *serverStream) RecvMsg(msg interface{}) error {
+	err := ss.ServerStream.RecvMsg(msg)
+	if err == nil {
+		ss.m.observeSize(ss.m.serverMsgSize, ss.service, ss.method, "recv", msg)
+	}
+	return err
+}
+
+func (ss *serverStream) SendMsg(msg interface{}) error {
+	err := ss.ServerStream.SendMsg(msg)
+	if err == nil {
+		ss.m.observeSize(ss.m.serverMsgSize, ss.service, ss.method, "sent", msg)
+	}
+	return err
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	m       *Metrics
+	desc    *grpc.StreamDesc
+	service string
+	method  string
+	done    func(err error)
+}
+
+func (cs *clientStream) SendMsg(msg interface{}) error {
+	err := cs.ClientStream.SendMsg(msg)
+	if err == nil {
+		cs.m.observeSize(cs.m.clientMsgSize, cs.service, cs.method, "sent", msg)
+	}
+	return err
+}
+
+func (cs *clientStream) RecvMsg(msg interface{}) error {
+	err := cs.ClientStream.RecvMsg(msg)
+	if err == nil {
+		cs.m.observeSize(cs.m.clientMsgSize, cs.service, cs.method, "recv", msg)
+	}
+	if err == io.EOF {
+		cs.done(nil)
+	} else if err != nil || !cs.desc.ServerStreams {
+		cs.done(err)
+	}
+	return err
+}
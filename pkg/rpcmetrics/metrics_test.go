@@ -0,0 +1,135 @@
+package rpcmetrics_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/obnahsgnaw/rpc/pkg/rpcclient"
+	"github.com/obnahsgnaw/rpc/pkg/rpcmetrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func newMetrics(t *testing.T) (*rpcmetrics.Metrics, *prometheus.Registry) {
+	reg := prometheus.NewRegistry()
+	m, err := rpcmetrics.New(reg, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, reg
+}
+
+func outgoing(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "rq_from", "order", "rq_to", "user")
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	m, reg := newMetrics(t)
+	i := m.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.User/Get"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	}
+	if _, err := i(context.Background(), wrapperspb.String("u1"), info, handler); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = i(context.Background(), wrapperspb.String("u2"), info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	})
+
+	expected := `
+# HELP test_rpc_server_handled_total Total number of rpc handled on the server, by result code.
+# TYPE test_rpc_server_handled_total counter
+test_rpc_server_handled_total{code="NotFound",from="",method="Get",service="user.User",to=""} 1
+test_rpc_server_handled_total{code="OK",from="",method="Get",service="user.User",to=""} 1
+# HELP test_rpc_server_in_flight Number of rpc in handling on the server.
+# TYPE test_rpc_server_in_flight gauge
+test_rpc_server_in_flight{method="Get",service="user.User"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "test_rpc_server_handled_total", "test_rpc_server_in_flight"); err != nil {
+		t.Fatal(err)
+	}
+	if n := testutil.CollectAndCount(reg, "test_rpc_server_msg_size_bytes"); n != 2 {
+		t.Fatalf("want the recv and sent sizes, got %d series", n)
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	m, reg := newMetrics(t)
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.Error(codes.Unavailable, "down")
+	}
+	err := m.UnaryClientInterceptor()(outgoing(context.Background()), "/user.User/Get", wrapperspb.String("u1"), &wrapperspb.StringValue{}, nil, invoker)
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("want the invoker error, got %v", err)
+	}
+
+	expected := `
+# HELP test_rpc_client_handled_total Total number of rpc completed by the client, by result code.
+# TYPE test_rpc_client_handled_total counter
+test_rpc_client_handled_total{code="Unavailable",from="order",method="Get",service="user.User",to="user"} 1
+`
+	if err = testutil.GatherAndCompare(reg, strings.NewReader(expected), "test_rpc_client_handled_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// endedStream a client stream ended by the server with err
+type endedStream struct {
+	grpc.ClientStream
+	err error
+}
+
+func (s endedStream) RecvMsg(interface{}) error {
+	return s.err
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	m, reg := newMetrics(t)
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return endedStream{err: status.Error(codes.Unavailable, "down")}, nil
+	}
+	desc := &grpc.StreamDesc{ServerStreams: true}
+	cs, err := m.StreamClientInterceptor()(outgoing(context.Background()), desc, nil, "/user.User/Watch", streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = cs.RecvMsg(&wrapperspb.StringValue{})
+
+	expected := `
+# HELP test_rpc_client_handled_total Total number of rpc completed by the client, by result code.
+# TYPE test_rpc_client_handled_total counter
+test_rpc_client_handled_total{code="Unavailable",from="order",method="Watch",service="user.User",to="user"} 1
+# HELP test_rpc_client_in_flight Number of rpc in calling by the client.
+# TYPE test_rpc_client_in_flight gauge
+test_rpc_client_in_flight{method="Watch",service="user.User"} 0
+`
+	if err = testutil.GatherAndCompare(reg, strings.NewReader(expected), "test_rpc_client_handled_total", "test_rpc_client_in_flight"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchManager(t *testing.T) {
+	m, reg := newMetrics(t)
+	manager := rpcclient.NewManager()
+	manager.Add("user", "127.0.0.1:8001")
+	manager.Add("user", "127.0.0.1:8002")
+	if err := m.WatchManager(manager); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+# HELP test_rpc_client_addr_pool_size Number of server addrs discovered for the module.
+# TYPE test_rpc_client_addr_pool_size gauge
+test_rpc_client_addr_pool_size{module="user"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "test_rpc_client_addr_pool_size"); err != nil {
+		t.Fatal(err)
+	}
+}
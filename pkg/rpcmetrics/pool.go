@@ -0,0 +1,48 @@
+package rpcmetrics
+
+import (
+	"github.com/obnahsgnaw/rpc/pkg/rpcclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector collect the addr pool size of each module from the manager on scrape
+type poolCollector struct {
+	manager *rpcclient.Manager
+	size    *prometheus.Desc
+	healthy *prometheus.Desc
+}
+
+func newPoolCollector(namespace string, manager *rpcclient.Manager) *poolCollector {
+	return &poolCollector{
+		manager: manager,
+		size: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "rpc_client_addr_pool_size"),
+			"Number of server addrs discovered for the module.",
+			[]string{"module"}, nil,
+		),
+		healthy: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "rpc_client_addr_pool_healthy"),
+			"Number of healthy server addrs of the module.",
+			[]string{"module"}, nil,
+		),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.size
+	ch <- c.healthy
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, module := range c.manager.Modules() {
+		healthy := 0
+		states := c.manager.HealthState(module)
+		for _, ok := range states {
+			if ok {
+				healthy++
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(len(states)), module.String())
+		ch <- prometheus.MustNewConstMetric(c.healthy, prometheus.GaugeValue, float64(healthy), module.String())
+	}
+}
@@ -1,27 +1,82 @@
 package rpcserver
 
-import "net"
+import (
+	"net"
+	"sync"
+)
 
+// acceptor keep at most one Accept pending on the shared underlying listener, which can not be unblocked without closing it,
+// the connection got after a NoCloseListener closed is taken by the next one, so no connection is lost on restart
+type acceptor struct {
+	l       net.Listener
+	mu      sync.Mutex
+	pending chan accepted
+}
+
+type accepted struct {
+	c   net.Conn
+	err error
+}
+
+func newAcceptor(l net.Listener) *acceptor {
+	return &acceptor{l: l}
+}
+
+// accept return the next connection, or net.ErrClosed once done, it is called by one NoCloseListener at a time
+func (a *acceptor) accept(done <-chan struct{}) (net.Conn, error) {
+	a.mu.Lock()
+	if a.pending == nil {
+		ch := make(chan accepted, 1)
+		go func() {
+			c, err := a.l.Accept()
+			ch <- accepted{c: c, err: err}
+		}()
+		a.pending = ch
+	}
+	ch := a.pending
+	a.mu.Unlock()
+	select {
+	case r := <-ch:
+		a.mu.Lock()
+		a.pending = nil
+		a.mu.Unlock()
+		return r.c, r.err
+	case <-done:
+		return nil, net.ErrClosed
+	}
+}
+
+// NoCloseListener keep the shared underlying listener open when grpc server stopped, only unblock the Accept
 type NoCloseListener struct {
-	l net.Listener
+	a    *acceptor
+	once sync.Once
+	done chan struct{}
 }
 
-func newNoCl(l net.Listener) *NoCloseListener {
-	return &NoCloseListener{l: l}
+func newNoCl(a *acceptor) *NoCloseListener {
+	return &NoCloseListener{a: a, done: make(chan struct{})}
 }
 
 // Accept waits for and returns the next connection to the listener.
 func (s *NoCloseListener) Accept() (net.Conn, error) {
-	return s.l.Accept()
+	select {
+	case <-s.done:
+		return nil, net.ErrClosed
+	default:
+	}
+	return s.a.accept(s.done)
 }
 
 // Close closes the listener.
 // Any blocked Accept operations will be unblocked and return errors.
 func (s *NoCloseListener) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+	})
 	return nil
 }
 
 // Addr returns the listener's network address.
 func (s *NoCloseListener) Addr() net.Addr {
-	return s.l.Addr()
+	return s.a.l.Addr()
 }
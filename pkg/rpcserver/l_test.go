@@ -0,0 +1,51 @@
+package rpcserver
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNoCloseListenerRestart(t *testing.T) {
+	raw, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	a := newAcceptor(raw)
+
+	// the accept of the stopped server is left pending on the underlying listener
+	first := newNoCl(a)
+	closed := make(chan error, 1)
+	go func() {
+		_, err := first.Accept()
+		closed <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	_ = first.Close()
+	if err = <-closed; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("want the ErrClosed after Close, got %v", err)
+	}
+
+	client, err := net.Dial("tcp", raw.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err = client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the restarted server take the connection got by the pending accept
+	c, err := newNoCl(a).Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_ = c.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 4)
+	if _, err = c.Read(b); err != nil || string(b) != "ping" {
+		t.Fatalf("the connection lost on restart, %v", err)
+	}
+}
@@ -5,17 +5,30 @@ import (
 	"errors"
 	"github.com/obnahsgnaw/application/pkg/utils"
 	"github.com/obnahsgnaw/http/listener"
+	"github.com/obnahsgnaw/rpc/pkg/rpcmeta"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type BeforeInterceptor func(ctx context.Context, head Header, req interface{}, info *grpc.UnaryServerInfo) error
 
 type AfterHandler func(ctx context.Context, head Header, req interface{}, info *grpc.UnaryServerInfo, resp interface{}, err error)
 
+type StreamBeforeInterceptor func(ctx context.Context, head Header, info *grpc.StreamServerInfo) error
+
+type StreamAfterHandler func(ctx context.Context, head Header, info *grpc.StreamServerInfo, err error)
+
+// StreamMsgHandler called after each message received(recv=true) or sent(recv=false) on a stream
+type StreamMsgHandler func(ctx context.Context, head Header, info *grpc.StreamServerInfo, msg interface{}, recv bool, err error)
+
 type Server struct {
 	lc                 sync.Mutex
 	server             *grpc.Server
@@ -23,9 +36,20 @@ type Server struct {
 	logger             *zap.Logger
 	beforeInterceptors []BeforeInterceptor
 	afterHandlers      []AfterHandler
+	streamBefores      []StreamBeforeInterceptor
+	streamAfters       []StreamAfterHandler
+	streamMsgHandlers  []StreamMsgHandler
+	unaryInterceptors  []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
 	services           []rpcService
 	startKey           string
+	health             *health.Server
+	inFlight           int64
 	errParser          func(err error) (code string, message string, statusCode string)
+	errMode            ErrorMode
+	tls                bool
+	shared             bool
+	acceptor           *acceptor
 }
 
 type Header struct {
@@ -34,6 +58,14 @@ type Header struct {
 	To     string
 	AppId  string
 	UserId string
+	// Meta the registered rpcmeta keys in the incoming metadata
+	Meta map[string]string
+}
+
+// ServiceInfo a service desc and its implementation, like the one built by the generated NewXxxServiceInfo
+type ServiceInfo struct {
+	Desc grpc.ServiceDesc
+	Impl interface{}
 }
 
 type rpcService struct {
@@ -46,47 +78,30 @@ func New(lr *listener.PortedListener, l *zap.Logger) *Server {
 		listener: lr,
 		server:   nil,
 		logger:   l,
+		health:   health.NewServer(),
 	}
-	s.server = grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-		defer utils.RecoverHandler("handle", func(err1, stack string) {
-			err = errors.New("handle failed, err=" + err1)
-			if s.logger != nil {
-				s.logger.Error("handle failed, err=" + err1 + ", stack=" + stack)
-			}
-		})
-		defer func() {
-			if err != nil {
-				var code = "1"
-				var statusCode = "500"
-				var message = err.Error()
-				if s.errParser != nil {
-					code, message, statusCode = s.errParser(err)
-				}
-				err = grpc.SetHeader(ctx, metadata.New(map[string]string{
-					"err_code":    code,
-					"err_message": message,
-					"err_status":  statusCode,
-				}))
-				err = nil
-			}
-		}()
-		head := s.parseHeader(ctx)
-		for _, h := range s.beforeInterceptors {
-			if err = h(ctx, head, req, info); err != nil {
-				return
-			}
-		}
-		resp, err = handler(ctx, req)
-		for _, h := range s.afterHandlers {
-			h(ctx, head, req, info, resp, err)
-		}
-		return
-	}))
+	s.server = grpc.NewServer(grpc.UnaryInterceptor(s.unaryInterceptor), grpc.StreamInterceptor(s.streamInterceptor))
 	return s
 }
 
 func (s *Server) Register(desc *grpc.ServiceDesc, serv interface{}) {
 	s.services = append(s.services, rpcService{desc: *desc, serv: serv})
+	s.health.SetServingStatus(desc.ServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+}
+
+// SetServingStatus set the health status of a service, service empty for the whole server
+func (s *Server) SetServingStatus(service string, status grpc_health_v1.HealthCheckResponse_ServingStatus) {
+	s.health.SetServingStatus(service, status)
+}
+
+// Shutdown set all the health status NOT_SERVING and ignore the later updates
+func (s *Server) Shutdown() {
+	s.health.Shutdown()
+}
+
+// Resume set all the health status SERVING and accept the later updates
+func (s *Server) Resume() {
+	s.health.Resume()
 }
 
 func (s *Server) Listener() *listener.PortedListener {
@@ -95,7 +110,7 @@ func (s *Server) Listener() *listener.PortedListener {
 
 func (s *Server) RegisterBeforeInterceptor(i BeforeInterceptor) {
 	if i != nil {
-		s.beforeInterceptors = append(s.beforeInterceptors)
+		s.beforeInterceptors = append(s.beforeInterceptors, i)
 	}
 }
 
@@ -105,6 +120,39 @@ func (s *Server) RegisterAfterHandler(h AfterHandler) {
 	}
 }
 
+func (s *Server) RegisterStreamBeforeInterceptor(i StreamBeforeInterceptor) {
+	if i != nil {
+		s.streamBefores = append(s.streamBefores, i)
+	}
+}
+
+func (s *Server) RegisterStreamAfterHandler(h StreamAfterHandler) {
+	if h != nil {
+		s.streamAfters = append(s.streamAfters, h)
+	}
+}
+
+func (s *Server) RegisterStreamMsgHandler(h StreamMsgHandler) {
+	if h != nil {
+		s.streamMsgHandlers = append(s.streamMsgHandlers, h)
+	}
+}
+
+// RegisterUnaryInterceptor the interceptor wrap the before interceptors, handler and after handlers,
+// the parsed Header is in the ctx, and the error is the one before sent as err_* header or status
+func (s *Server) RegisterUnaryInterceptor(i grpc.UnaryServerInterceptor) {
+	if i != nil {
+		s.unaryInterceptors = append(s.unaryInterceptors, i)
+	}
+}
+
+// RegisterStreamInterceptor the stream version of RegisterUnaryInterceptor
+func (s *Server) RegisterStreamInterceptor(i grpc.StreamServerInterceptor) {
+	if i != nil {
+		s.streamInterceptors = append(s.streamInterceptors, i)
+	}
+}
+
 func (s *Server) Start(key string) error {
 	s.lc.Lock()
 	defer s.lc.Unlock()
@@ -113,9 +161,15 @@ func (s *Server) Start(key string) error {
 	}
 	s.startKey = key
 	s.init()
-	l := s.listener.GrpcListener()
-	l = newNoCl(l)
-	err := s.server.Serve(l)
+	// the grpc listener is matched once, and shared by the restarts
+	if s.acceptor == nil {
+		if s.tls {
+			s.acceptor = newAcceptor(s.listener.RawListener())
+		} else {
+			s.acceptor = newAcceptor(s.listener.GrpcListener())
+		}
+	}
+	err := s.server.Serve(newNoCl(s.acceptor))
 	if err != nil {
 		s.startKey = ""
 	}
@@ -142,6 +196,39 @@ func (s *Server) Close(key string) {
 	s.startKey = ""
 }
 
+// InFlight return the count of the rpc in handling
+func (s *Server) InFlight() int64 {
+	return atomic.LoadInt64(&s.inFlight)
+}
+
+// Drain stop accepting new rpc and wait the in-flight ones to finish, force stop after the timeout,
+// progress is called each second with the in-flight count, return false if forced
+func (s *Server) Drain(timeout time.Duration, progress func(inFlight int64)) bool {
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return true
+		case <-ticker.C:
+			if progress != nil {
+				progress(s.InFlight())
+			}
+		case <-deadline.C:
+			s.server.Stop()
+			<-done
+			return false
+		}
+	}
+}
+
 func (s *Server) Addr() string {
 	return "tcp:" + strconv.Itoa(s.listener.Port())
 }
@@ -151,9 +238,152 @@ func (s *Server) Port() int {
 }
 
 func (s *Server) init() {
+	registered := false
 	for _, h := range s.services {
 		s.server.RegisterService(&h.desc, h.serv)
+		registered = registered || h.desc.ServiceName == grpc_health_v1.Health_ServiceDesc.ServiceName
+	}
+	// keep the health service registered by user
+	if !registered {
+		grpc_health_v1.RegisterHealthServer(s.server, s.health)
+	}
+}
+
+// isHealthMethod the built-in health service skip the interceptors, so the probes are not blocked or logged
+func isHealthMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/grpc.health.v1.Health/")
+}
+
+func (s *Server) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	if isHealthMethod(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	atomic.AddInt64(&s.inFlight, 1)
+	defer atomic.AddInt64(&s.inFlight, -1)
+	defer utils.RecoverHandler("handle", func(err1, stack string) {
+		err = s.panicErr(err1, stack)
+	})
+	defer func() {
+		if err != nil {
+			if s.sentAsStatus(ctx, err) {
+				err = s.errStatus(err)
+				return
+			}
+			err = grpc.SetHeader(ctx, s.errMetadata(err))
+			err = nil
+		}
+	}()
+	head := s.parseHeader(ctx)
+	ctx = newHeaderContext(ctx, head)
+	return s.chainUnary(ctx, req, info, func(ctx context.Context, req interface{}) (resp interface{}, err error) {
+		for _, h := range s.beforeInterceptors {
+			if err = h(ctx, head, req, info); err != nil {
+				return
+			}
+		}
+		resp, err = handler(ctx, req)
+		for _, h := range s.afterHandlers {
+			h(ctx, head, req, info, resp, err)
+		}
+		return
+	})
+}
+
+func (s *Server) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	if isHealthMethod(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	atomic.AddInt64(&s.inFlight, 1)
+	defer atomic.AddInt64(&s.inFlight, -1)
+	defer utils.RecoverHandler("handle", func(err1, stack string) {
+		err = s.panicErr(err1, stack)
+	})
+	defer func() {
+		if err != nil {
+			if s.sentAsStatus(ss.Context(), err) {
+				err = s.errStatus(err)
+				return
+			}
+			md := s.errMetadata(err)
+			// the header has been sent once a message was sent, fall back to the trailer
+			if err = ss.SetHeader(md); err != nil {
+				ss.SetTrailer(md)
+			}
+			err = nil
+		}
+	}()
+	head := s.parseHeader(ss.Context())
+	ss = &serverStream{ServerStream: ss, s: s, ctx: newHeaderContext(ss.Context(), head), head: head, info: info}
+	return s.chainStream(srv, ss, info, func(srv interface{}, ss grpc.ServerStream) (err error) {
+		ctx := ss.Context()
+		for _, h := range s.streamBefores {
+			if err = h(ctx, head, info); err != nil {
+				return
+			}
+		}
+		err = handler(srv, ss)
+		for _, h := range s.streamAfters {
+			h(ctx, head, info, err)
+		}
+		return
+	})
+}
+
+// chainUnary wrap the handler with the unary interceptors, the first registered is the outermost
+func (s *Server) chainUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	for i := len(s.unaryInterceptors) - 1; i >= 0; i-- {
+		interceptor, next := s.unaryInterceptors[i], handler
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return interceptor(ctx, req, info, next)
+		}
+	}
+	return handler(ctx, req)
+}
+
+// chainStream wrap the handler with the stream interceptors, the first registered is the outermost
+func (s *Server) chainStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	for i := len(s.streamInterceptors) - 1; i >= 0; i-- {
+		interceptor, next := s.streamInterceptors[i], handler
+		handler = func(srv interface{}, ss grpc.ServerStream) error {
+			return interceptor(srv, ss, info, next)
+		}
+	}
+	return handler(srv, ss)
+}
+
+func (s *Server) panicErr(err, stack string) error {
+	if s.logger != nil {
+		s.logger.Error("handle failed, err=" + err + ", stack=" + stack)
 	}
+	return errors.New("handle failed, err=" + err)
+}
+
+func (s *Server) errMetadata(err error) metadata.MD {
+	code, message, statusCode := s.parseErr(err)
+	return metadata.New(map[string]string{
+		"err_code":    code,
+		"err_message": message,
+		"err_status":  statusCode,
+	})
+}
+
+// newHeaderContext the meta is also set to the rpcmeta of ctx, so it is read by the rpcmeta accessors and propagated by the calls with ctx
+func newHeaderContext(ctx context.Context, head Header) context.Context {
+	if len(head.Meta) > 0 {
+		ctx = rpcmeta.NewContext(ctx, head.Meta)
+	}
+	return context.WithValue(ctx, "RpcHeader", head)
+}
+
+// HeaderFromContext return the Header parsed from the incoming metadata in the handler ctx
+func HeaderFromContext(ctx context.Context) (Header, bool) {
+	head, ok := ctx.Value("RpcHeader").(Header)
+	return head, ok
+}
+
+// SetCacheTtl send the cache_ttl header in the handler, the rpcclient cache the response for ttl, 0 to not cache it
+func SetCacheTtl(ctx context.Context, ttl time.Duration) error {
+	return grpc.SetHeader(ctx, metadata.Pairs("cache_ttl", strconv.Itoa(int(ttl/time.Second))))
 }
 
 func (s *Server) parseHeader(ctx context.Context) Header {
@@ -187,6 +417,7 @@ func (s *Server) parseHeader(ctx context.Context) Header {
 		To:     rqTo,
 		AppId:  appId,
 		UserId: userId,
+		Meta:   rpcmeta.FromMD(md),
 	}
 }
 
@@ -0,0 +1,204 @@
+package rpcserver
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/obnahsgnaw/rpc/internal/rpcutil"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// ErrorMode how the handler errors are sent to the caller
+type ErrorMode int
+
+const (
+	// ErrorModeHeader the legacy mode, the error is written into the err_* response header and the rpc succeed
+	ErrorModeHeader ErrorMode = iota
+	// ErrorModeStatus the error is sent as google.rpc.Status with an ErrorInfo detail
+	ErrorModeStatus
+	// ErrorModeAuto the status mode for the callers accept it(rq_err_mode=status), the header mode for the others
+	ErrorModeAuto
+)
+
+// ErrorDomain the domain of the ErrorInfo detail carrying the code and status
+const ErrorDomain = rpcutil.ErrorDomain
+
+// DetailError an error with the detail protos sent in the status mode
+type DetailError struct {
+	err     error
+	details []proto.Message
+}
+
+func NewDetailError(err error, details ...proto.Message) *DetailError {
+	return &DetailError{err: err, details: details}
+}
+
+func (e *DetailError) Error() string {
+	return e.err.Error()
+}
+
+func (e *DetailError) Unwrap() error {
+	return e.err
+}
+
+func (e *DetailError) Details() []proto.Message {
+	return e.details
+}
+
+// ThrottleReason the ErrorInfo reason of the throttled calls, the retry_after metadata is the wait in milliseconds
+const ThrottleReason = rpcutil.ThrottleReason
+
+// ThrottleError the error of the throttled calls, it is sent as the ResourceExhausted status in all the error modes,
+// the rpcclient returns it as the retryable RpsError of ErrThrottled
+type ThrottleError struct {
+	message    string
+	retryAfter time.Duration
+}
+
+func NewThrottleError(message string, retryAfter time.Duration) *ThrottleError {
+	return &ThrottleError{message: message, retryAfter: retryAfter}
+}
+
+func (e *ThrottleError) Error() string {
+	return e.message
+}
+
+// RetryAfter return the wait before the call may be allowed
+func (e *ThrottleError) RetryAfter() time.Duration {
+	return e.retryAfter
+}
+
+func (e *ThrottleError) GRPCStatus() *status.Status {
+	st := status.New(codes.ResourceExhausted, e.message)
+	if st1, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   ThrottleReason,
+		Domain:   ErrorDomain,
+		Metadata: map[string]string{"retry_after": strconv.FormatInt(e.retryAfter.Milliseconds(), 10)},
+	}); err == nil {
+		st = st1
+	}
+	return st
+}
+
+// AccessError the error of the calls rejected by the access control, authentication or signature check,
+// it is sent with the err_status 401 or 403 in the header mode and as the Unauthenticated or PermissionDenied status
+// in the status mode, the rpcclient returns it as the CustomError with the status
+type AccessError struct {
+	message string
+	status  string
+}
+
+// NewUnauthenticatedError the error of the calls without a valid credential or signature, status 401
+func NewUnauthenticatedError(message string) *AccessError {
+	return &AccessError{message: message, status: "401"}
+}
+
+// NewPermissionDeniedError the error of the calls not allowed for the caller, status 403
+func NewPermissionDeniedError(message string) *AccessError {
+	return &AccessError{message: message, status: "403"}
+}
+
+func (e *AccessError) Error() string {
+	return e.message
+}
+
+// Status return the http like status, 401 or 403
+func (e *AccessError) Status() string {
+	return e.status
+}
+
+// sentAsStatus return if the error of the rpc should be sent as status, the throttle errors are always
+func (s *Server) sentAsStatus(ctx context.Context, err error) bool {
+	var throttleErr *ThrottleError
+	return s.statusMode(ctx) || errors.As(err, &throttleErr)
+}
+
+func (s *Server) SetErrorMode(mode ErrorMode) {
+	s.errMode = mode
+}
+
+// statusMode return if the error of the rpc should be sent as status
+func (s *Server) statusMode(ctx context.Context) bool {
+	switch s.errMode {
+	case ErrorModeStatus:
+		return true
+	case ErrorModeAuto:
+		md, _ := metadata.FromIncomingContext(ctx)
+		modes := md.Get("rq_err_mode")
+		return len(modes) > 0 && modes[0] == "status"
+	default:
+		return false
+	}
+}
+
+// errStatus convert the handler error to the status error, the status errors are sent as is
+func (s *Server) errStatus(err error) error {
+	if _, ok := err.(interface{ GRPCStatus() *status.Status }); ok {
+		return err
+	}
+	code, message, statusCode := s.parseErr(err)
+	st := status.New(httpToCode(statusCode), message)
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{
+		Reason:   "RPC_ERROR",
+		Domain:   ErrorDomain,
+		Metadata: map[string]string{"code": code, "status": statusCode},
+	}}
+	var detailErr *DetailError
+	if errors.As(err, &detailErr) {
+		for _, d := range detailErr.details {
+			details = append(details, protoadapt.MessageV1Of(d))
+		}
+	}
+	if st1, err1 := st.WithDetails(details...); err1 == nil {
+		st = st1
+	} else if s.logger != nil {
+		s.logger.Warn("attach error details failed, err=" + err1.Error())
+	}
+	return st.Err()
+}
+
+// parseErr return the code, message and status of the handler error, the access errors take precedence over the error parser
+func (s *Server) parseErr(err error) (code, message, statusCode string) {
+	var accessErr *AccessError
+	if errors.As(err, &accessErr) {
+		return "1", accessErr.message, accessErr.status
+	}
+	if s.errParser != nil {
+		return s.errParser(err)
+	}
+	return "1", err.Error(), "500"
+}
+
+// httpToCode map the http like status code to the grpc code for the non-rpcclient callers
+func httpToCode(statusCode string) codes.Code {
+	c, _ := strconv.Atoi(statusCode)
+	switch c {
+	case 400:
+		return codes.InvalidArgument
+	case 401:
+		return codes.Unauthenticated
+	case 403:
+		return codes.PermissionDenied
+	case 404:
+		return codes.NotFound
+	case 409:
+		return codes.AlreadyExists
+	case 429:
+		return codes.ResourceExhausted
+	case 501:
+		return codes.Unimplemented
+	case 503:
+		return codes.Unavailable
+	case 504:
+		return codes.DeadlineExceeded
+	default:
+		return codes.Unknown
+	}
+}
@@ -0,0 +1,36 @@
+package rpcserver
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// serverStream wrap the grpc.ServerStream to carry the Header in ctx and dispatch the message handlers
+type serverStream struct {
+	grpc.ServerStream
+	s    *Server
+	ctx  context.Context
+	head Header
+	info *grpc.StreamServerInfo
+}
+
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *serverStream) RecvMsg(m interface{}) error {
+	err := ss.ServerStream.RecvMsg(m)
+	for _, h := range ss.s.streamMsgHandlers {
+		h(ss.Context(), ss.head, ss.info, m, true, err)
+	}
+	return err
+}
+
+func (ss *serverStream) SendMsg(m interface{}) error {
+	err := ss.ServerStream.SendMsg(m)
+	for _, h := range ss.s.streamMsgHandlers {
+		h(ss.Context(), ss.head, ss.info, m, false, err)
+	}
+	return err
+}
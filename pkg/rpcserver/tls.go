@@ -0,0 +1,82 @@
+package rpcserver
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/obnahsgnaw/rpc/pkg/rpctls"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+// SetTLS serve with TLS, the client certs are required and verified if the CAFile set, call it before Start.
+// The TLS connections can not be matched on the shared port, so the server accepts on the raw listener,
+// it fails if the listener is shared by ShareListener
+func (s *Server) SetTLS(cnf *rpctls.Config) error {
+	if s.shared {
+		return errors.New("the listener is shared with the other servers, TLS needs a dedicated port")
+	}
+	creds, err := cnf.ServerCredentials()
+	if err != nil {
+		return err
+	}
+	s.server = grpc.NewServer(grpc.UnaryInterceptor(s.unaryInterceptor), grpc.StreamInterceptor(s.streamInterceptor), grpc.Creds(creds))
+	s.tls = true
+	return nil
+}
+
+// ShareListener mark the listener shared with the other servers on the port, like the http server,
+// it fails if the server serve with TLS
+func (s *Server) ShareListener() error {
+	if s.tls {
+		return errors.New("the TLS server accepts on the whole port, the listener can not be shared")
+	}
+	s.shared = true
+	return nil
+}
+
+// TLS return if the server serve with TLS
+func (s *Server) TLS() bool {
+	return s.tls
+}
+
+// PeerSANs return the SANs of the verified client cert in the handler ctx, nil if not mutual TLS
+func PeerSANs(ctx context.Context) []string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return rpctls.PeerSANs(p.AuthInfo)
+}
+
+// CheckPeerFrom a BeforeInterceptor reject the call with the 401 AccessError if the client cert not verified,
+// or the 403 one if no SAN of the client cert matches head.From,
+// a SAN matches if equal or it is an URI whose last path segment equal, like spiffe://cluster/ns/default/sa/user
+func CheckPeerFrom(ctx context.Context, head Header, req interface{}, info *grpc.UnaryServerInfo) error {
+	return checkPeerFrom(ctx, head)
+}
+
+// CheckStreamPeerFrom the StreamBeforeInterceptor version of CheckPeerFrom
+func CheckStreamPeerFrom(ctx context.Context, head Header, info *grpc.StreamServerInfo) error {
+	return checkPeerFrom(ctx, head)
+}
+
+func checkPeerFrom(ctx context.Context, head Header) error {
+	sans := PeerSANs(ctx)
+	if len(sans) == 0 {
+		return NewUnauthenticatedError("peer cert not verified")
+	}
+	for _, san := range sans {
+		if san == head.From {
+			return nil
+		}
+		if u, err := url.Parse(san); err == nil && u.Scheme != "" && u.Path != "" {
+			if u.Path[strings.LastIndex(u.Path, "/")+1:] == head.From {
+				return nil
+			}
+		}
+	}
+	return NewPermissionDeniedError("peer cert not match the from " + head.From)
+}
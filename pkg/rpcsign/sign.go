@@ -0,0 +1,229 @@
+package rpcsign
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/obnahsgnaw/rpc/pkg/rpcclient"
+	"github.com/obnahsgnaw/rpc/pkg/rpcserver"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Key a shared secret, the Id is sent with the signature, so the verifier can accept several keys during the rotation
+type Key struct {
+	Id     string
+	Secret []byte
+}
+
+// Sign return the base64 HMAC-SHA256 of the canonical header tuple, the fields are joined by \n:
+// rq_id, rq_from, rq_to, app_id, user_id, rq_ts(unix milli), rq_nonce
+func Sign(secret []byte, head rpcserver.Header, ts, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{head.RqId, head.From, head.To, head.AppId, head.UserId, ts, nonce}, "\n")))
+	return base64.RawStdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Signer sign the header of the called rpc, the signature is sent as the rq_sig metadata "<key id>:<signature>"
+type Signer struct {
+	sync.RWMutex
+	key  *Key
+	keys map[string]*Key
+}
+
+// NewSigner key the default key of the modules without one, nil for not signing them
+func NewSigner(key *Key) *Signer {
+	return &Signer{key: key, keys: make(map[string]*Key)}
+}
+
+// SetKey set the default key, switch it to rotate after the verifiers accept the new one
+func (s *Signer) SetKey(key *Key) {
+	s.Lock()
+	defer s.Unlock()
+	s.key = key
+}
+
+// SetModuleKey set the key of the calls to the module, nil to use the default
+func (s *Signer) SetModuleKey(module string, key *Key) {
+	s.Lock()
+	defer s.Unlock()
+	if key == nil {
+		delete(s.keys, module)
+	} else {
+		s.keys[module] = key
+	}
+}
+
+func (s *Signer) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, fullMethod string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(s.sign(ctx), fullMethod, req, reply, cc, opts...)
+	}
+}
+
+func (s *Signer) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, fullMethod string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(s.sign(ctx), desc, cc, fullMethod, opts...)
+	}
+}
+
+// sign append the rq_ts, rq_nonce and rq_sig, each attempt is signed with a new nonce
+func (s *Signer) sign(ctx context.Context) context.Context {
+	h := rpcclient.HeaderFromOutgoingContext(ctx)
+	s.RLock()
+	key, ok := s.keys[h.To]
+	if !ok {
+		key = s.key
+	}
+	s.RUnlock()
+	if key == nil {
+		return ctx
+	}
+	head := rpcserver.Header{RqId: h.RqId, From: h.From, To: h.To, AppId: h.AppId, UserId: h.UserId}
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	nonce := newNonce()
+	return metadata.AppendToOutgoingContext(ctx, "rq_ts", ts, "rq_nonce", nonce, "rq_sig", key.Id+":"+Sign(key.Secret, head, ts, nonce))
+}
+
+func newNonce() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Verifier verify the signature of the served rpc by the keys of the rq_from module,
+// and reject the calls out of the clock skew window or replayed in it with the 401 AccessError
+type Verifier struct {
+	sync.RWMutex
+	keys     map[string][]Key
+	window   time.Duration
+	logger   *zap.Logger
+	nonceMu  sync.Mutex
+	nonces   map[string]time.Time
+	prunedAt time.Time
+}
+
+// NewVerifier window the max clock skew and the replay window, default 1 minute
+func NewVerifier(window time.Duration, l *zap.Logger) *Verifier {
+	if window <= 0 {
+		window = time.Minute
+	}
+	return &Verifier{keys: make(map[string][]Key), window: window, logger: l, nonces: make(map[string]time.Time)}
+}
+
+// SetKeys set the active keys of the from module, * for the modules without keys, nil to remove them
+func (v *Verifier) SetKeys(from string, keys ...Key) {
+	v.Lock()
+	defer v.Unlock()
+	if len(keys) == 0 {
+		delete(v.keys, from)
+	} else {
+		v.keys[from] = keys
+	}
+}
+
+func (v *Verifier) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := v.verify(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (v *Verifier) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := v.verify(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func (v *Verifier) verify(ctx context.Context, fullMethod string) error {
+	head, _ := rpcserver.HeaderFromContext(ctx)
+	err := v.check(ctx, head)
+	if err == nil {
+		return nil
+	}
+	if v.logger != nil {
+		v.logger.Warn("rpc sign verify failed, "+err.Error(), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.RqId), zap.String("method", fullMethod))
+	}
+	return rpcserver.NewUnauthenticatedError(err.Error())
+}
+
+func (v *Verifier) check(ctx context.Context, head rpcserver.Header) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ts, nonce, sig := first(md, "rq_ts"), first(md, "rq_nonce"), first(md, "rq_sig")
+	if ts == "" || nonce == "" || sig == "" {
+		return errors.New("rpc sign: not signed")
+	}
+	millis, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("rpc sign: invalid rq_ts")
+	}
+	signedAt := time.UnixMilli(millis)
+	if skew := time.Since(signedAt); skew > v.window || skew < -v.window {
+		return errors.New("rpc sign: rq_ts out of the window")
+	}
+	keyId, signature, _ := strings.Cut(sig, ":")
+	v.RLock()
+	keys, ok := v.keys[head.From]
+	if !ok {
+		keys = v.keys["*"]
+	}
+	v.RUnlock()
+	var key *Key
+	for i := range keys {
+		if keys[i].Id == keyId {
+			key = &keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return errors.New("rpc sign: unknown key " + keyId + " of " + head.From)
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(key.Secret, head, ts, nonce))) {
+		return errors.New("rpc sign: invalid signature")
+	}
+	if !v.remember(nonce, signedAt.Add(v.window)) {
+		return errors.New("rpc sign: replayed")
+	}
+	return nil
+}
+
+// remember return false if the nonce is seen, the nonces are kept until the signed time out of the window
+func (v *Verifier) remember(nonce string, expires time.Time) bool {
+	v.nonceMu.Lock()
+	defer v.nonceMu.Unlock()
+	now := time.Now()
+	if now.Sub(v.prunedAt) > v.window {
+		v.prunedAt = now
+		for n, exp := range v.nonces {
+			if now.After(exp) {
+				delete(v.nonces, n)
+			}
+		}
+	}
+	if _, ok := v.nonces[nonce]; ok {
+		return false
+	}
+	v.nonces[nonce] = expires
+	return true
+}
+
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,230 @@
+package rpctls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// Config the TLS of the rpc server or client, the cert, key and CA files are reloaded when modified without a restart
+type Config struct {
+	// CertFile and KeyFile the cert of the server, or the client cert for the mutual TLS
+	CertFile string
+	KeyFile  string
+	// CAFile the CA pool to verify the peer, the server require and verify the client certs with it,
+	// the client use the system pool when empty
+	CAFile string
+	// ServerName the client override of the server name to verify and send as SNI, default the host of the addr
+	ServerName string
+	// Authority the client override of the :authority header, default the addr
+	Authority string
+	// ReloadInterval the min interval to check the files modification on handshake, default 10s
+	ReloadInterval time.Duration
+}
+
+// ServerCredentials the credentials of the rpc server
+func (c *Config) ServerCredentials() (credentials.TransportCredentials, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, errors.New("rpctls: server cert and key required")
+	}
+	r, err := newReloader(c)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(&tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool, err := r.load()
+			if err != nil {
+				return nil, err
+			}
+			cnf := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				NextProtos:   []string{"h2"},
+			}
+			if pool != nil {
+				cnf.ClientAuth = tls.RequireAndVerifyClientCert
+				cnf.ClientCAs = pool
+			}
+			return cnf, nil
+		},
+	}), nil
+}
+
+// ClientCredentials the credentials of the rpc client, the server cert is verified by the current CA pool on each handshake,
+// against the ServerName, or the host of the dialed addr including the IP
+func (c *Config) ClientCredentials() (credentials.TransportCredentials, error) {
+	r, err := newReloader(c)
+	if err != nil {
+		return nil, err
+	}
+	cnf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// the chain and name are verified by VerifyConnection with the reloaded pool
+		InsecureSkipVerify: true,
+	}
+	if c.CertFile != "" && c.KeyFile != "" {
+		cnf.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _, err := r.load()
+			return cert, err
+		}
+	}
+	return &clientCredentials{TransportCredentials: credentials.NewTLS(cnf), cnf: cnf, serverName: c.ServerName, r: r}, nil
+}
+
+// clientCredentials verify the server cert against the name dialed, the tls ServerName is empty for an IP addr,
+// so it is taken from the authority of the handshake
+type clientCredentials struct {
+	credentials.TransportCredentials
+	cnf        *tls.Config
+	serverName string
+	r          *reloader
+}
+
+func (c *clientCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	name := c.serverName
+	if name == "" {
+		name = authority
+		if host, _, err := net.SplitHostPort(authority); err == nil {
+			name = host
+		}
+	}
+	if name == "" {
+		return nil, nil, errors.New("rpctls: no server name to verify")
+	}
+	cnf := c.cnf.Clone()
+	cnf.ServerName = name
+	cnf.VerifyConnection = func(cs tls.ConnectionState) error {
+		return c.verify(cs, name)
+	}
+	return credentials.NewTLS(cnf).ClientHandshake(ctx, authority, rawConn)
+}
+
+func (c *clientCredentials) verify(cs tls.ConnectionState, name string) error {
+	_, pool, err := c.r.load()
+	if err != nil {
+		return err
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("rpctls: no server cert")
+	}
+	// DNSName is checked by VerifyHostname, which matches the IP SANs for an IP
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       name,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err = cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+func (c *clientCredentials) Clone() credentials.TransportCredentials {
+	return &clientCredentials{TransportCredentials: c.TransportCredentials.Clone(), cnf: c.cnf.Clone(), serverName: c.serverName, r: c.r}
+}
+
+func (c *clientCredentials) OverrideServerName(name string) error {
+	c.serverName = name
+	return nil
+}
+
+// reloader load the cert and CA pool, and reload them when the files modified
+type reloader struct {
+	sync.Mutex
+	cnf       *Config
+	interval  time.Duration
+	checkedAt time.Time
+	modTime   time.Time
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+}
+
+func newReloader(cnf *Config) (*reloader, error) {
+	r := &reloader{cnf: cnf, interval: cnf.ReloadInterval}
+	if r.interval <= 0 {
+		r.interval = 10 * time.Second
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load return the current cert and pool, the pool nil for the system one
+func (r *reloader) load() (*tls.Certificate, *x509.CertPool, error) {
+	r.Lock()
+	defer r.Unlock()
+	if time.Since(r.checkedAt) >= r.interval {
+		r.checkedAt = time.Now()
+		if r.latestModTime().After(r.modTime) {
+			// keep serving the old ones if the new files are broken, such as in the middle of writing
+			_ = r.reload()
+		}
+	}
+	return r.cert, r.pool, nil
+}
+
+func (r *reloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, f := range []string{r.cnf.CertFile, r.cnf.KeyFile, r.cnf.CAFile} {
+		if f == "" {
+			continue
+		}
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (r *reloader) reload() error {
+	modTime := r.latestModTime()
+	var cert *tls.Certificate
+	if r.cnf.CertFile != "" && r.cnf.KeyFile != "" {
+		c, err := tls.LoadX509KeyPair(r.cnf.CertFile, r.cnf.KeyFile)
+		if err != nil {
+			return errors.New("rpctls: load cert failed, " + err.Error())
+		}
+		cert = &c
+	}
+	var pool *x509.CertPool
+	if r.cnf.CAFile != "" {
+		b, err := os.ReadFile(r.cnf.CAFile)
+		if err != nil {
+			return errors.New("rpctls: load ca failed, " + err.Error())
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return errors.New("rpctls: no cert in ca file")
+		}
+	}
+	r.cert, r.pool, r.modTime = cert, pool, modTime
+	return nil
+}
+
+// PeerSANs return the SANs of the verified peer cert, the DNS names, URIs, IPs and emails
+func PeerSANs(info credentials.AuthInfo) []string {
+	tlsInfo, ok := info.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := tlsInfo.State.VerifiedChains[0][0]
+	sans := append([]string(nil), cert.DNSNames...)
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return append(sans, cert.EmailAddresses...)
+}
@@ -0,0 +1,195 @@
+package rpctrace
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/obnahsgnaw/rpc/internal/rpcutil"
+	"github.com/obnahsgnaw/rpc/pkg/rpcclient"
+	"github.com/obnahsgnaw/rpc/pkg/rpcserver"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const instrumentationName = "github.com/obnahsgnaw/rpc/pkg/rpctrace"
+
+// Tracer create the opentelemetry spans of the server and client rpc,
+// the span context and baggage are propagated by the W3C traceparent and baggage metadata
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New tp nil for the otel global provider, propagator nil for the W3C trace context and baggage
+func New(tp trace.TracerProvider, propagator propagation.TextMapPropagator) *Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if propagator == nil {
+		propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	}
+	return &Tracer{tracer: tp.Tracer(instrumentationName), propagator: propagator}
+}
+
+func (t *Tracer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		head, _ := rpcserver.HeaderFromContext(ctx)
+		ctx, span := t.startServer(ctx, info.FullMethod, head)
+		resp, err := handler(ctx, req)
+		endSpan(span, err)
+		return resp, err
+	}
+}
+
+func (t *Tracer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		head, _ := rpcserver.HeaderFromContext(ss.Context())
+		ctx, span := t.startServer(ss.Context(), info.FullMethod, head)
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		endSpan(span, err)
+		return err
+	}
+}
+
+func (t *Tracer) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, fullMethod string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := t.startClient(ctx, fullMethod)
+		err := invoker(ctx, fullMethod, req, reply, cc, opts...)
+		endSpan(span, err)
+		return err
+	}
+}
+
+func (t *Tracer) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, fullMethod string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := t.startClient(ctx, fullMethod)
+		var once sync.Once
+		done := func(err error) {
+			once.Do(func() {
+				endSpan(span, err)
+			})
+		}
+		cs, err := streamer(ctx, desc, cc, fullMethod, opts...)
+		if err != nil {
+			done(err)
+			return nil, err
+		}
+		rpcutil.FinishOnDone(ctx, done)
+		return &clientStream{ClientStream: cs, desc: desc, done: done}, nil
+	}
+}
+
+// startServer start the server span as the child of the span context extracted from the incoming metadata
+func (t *Tracer) startServer(ctx context.Context, fullMethod string, head rpcserver.Header) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = t.propagator.Extract(ctx, metadataCarrier(md))
+	return t.tracer.Start(ctx, spanName(fullMethod),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs(fullMethod, head.From, head.To, head.AppId, head.UserId, head.RqId)...),
+	)
+}
+
+// startClient start the client span and inject its span context into the outgoing metadata
+func (t *Tracer) startClient(ctx context.Context, fullMethod string) (context.Context, trace.Span) {
+	head := rpcclient.HeaderFromOutgoingContext(ctx)
+	ctx, span := t.tracer.Start(ctx, spanName(fullMethod),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs(fullMethod, head.From, head.To, head.AppId, head.UserId, head.RqId)...),
+	)
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	t.propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+func attrs(fullMethod, from, to, appId, userId, rqId string) []attribute.KeyValue {
+	service, method := rpcutil.SplitMethod(fullMethod)
+	return []attribute.KeyValue{
+		attribute.String("rpc.system", "grpc"),
+		attribute.String("rpc.service", service),
+		attribute.String("rpc.method", method),
+		attribute.String("rq_from", from),
+		attribute.String("rq_to", to),
+		attribute.String("app_id", appId),
+		attribute.String("user_id", userId),
+		attribute.String("rq_id", rqId),
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	code := status.Code(err)
+	span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(code)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+	} else if code != codes.OK {
+		span.SetStatus(otelcodes.Error, code.String())
+	}
+	span.End()
+}
+
+// spanName the full method without the leading slash, package.service/method
+func spanName(fullMethod string) string {
+	return strings.TrimPrefix(fullMethod, "/")
+}
+
+// metadataCarrier the propagation.TextMapCarrier of the grpc metadata
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	desc *grpc.StreamDesc
+	done func(err error)
+}
+
+func (cs *clientStream) RecvMsg(msg interface{}) error {
+	err := cs.ClientStream.RecvMsg(msg)
+	if err == io.EOF {
+		cs.done(nil)
+	} else if err != nil || !cs.desc.ServerStreams {
+		cs.done(err)
+	}
+	return err
+}
@@ -0,0 +1,99 @@
+package rpctrace_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/obnahsgnaw/rpc/pkg/rpctrace"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func newTracer() (*rpctrace.Tracer, *tracetest.SpanRecorder) {
+	sr := tracetest.NewSpanRecorder()
+	return rpctrace.New(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)), nil), sr
+}
+
+func outgoing(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "rq_from", "order", "rq_to", "user", "rq_id", "r1")
+}
+
+func attr(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// serve pass the outgoing metadata of the client to the server interceptor as the incoming one
+func serve(t *rpctrace.Tracer, handler grpc.UnaryHandler) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		ctx = metadata.NewIncomingContext(context.Background(), md)
+		_, err := t.UnaryServerInterceptor()(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+}
+
+func TestUnaryPropagation(t *testing.T) {
+	tracer, sr := newTracer()
+	var handled trace.SpanContext
+	invoker := serve(tracer, func(ctx context.Context, req interface{}) (interface{}, error) {
+		handled = trace.SpanContextFromContext(ctx)
+		return req, nil
+	})
+	err := tracer.UnaryClientInterceptor()(outgoing(context.Background()), "/user.User/Get", wrapperspb.String("u1"), &wrapperspb.StringValue{}, nil, invoker)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := sr.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("want the server and client spans, got %d", len(spans))
+	}
+	server, client := spans[0], spans[1]
+	if server.SpanKind() != trace.SpanKindServer || client.SpanKind() != trace.SpanKindClient {
+		t.Fatalf("want the server span ended first, got %s and %s", server.SpanKind(), client.SpanKind())
+	}
+	if server.Parent().SpanID() != client.SpanContext().SpanID() || server.SpanContext().TraceID() != client.SpanContext().TraceID() {
+		t.Fatal("the server span is not the child of the client span")
+	}
+	if !handled.Equal(server.SpanContext()) {
+		t.Fatal("the handler ctx is not bound to the server span")
+	}
+	if client.Name() != "user.User/Get" {
+		t.Fatalf("unexpected span name %s", client.Name())
+	}
+	for key, want := range map[string]string{"rpc.service": "user.User", "rpc.method": "Get", "rq_from": "order", "rq_to": "user", "rq_id": "r1"} {
+		if got := attr(client, key).AsString(); got != want {
+			t.Fatalf("want %s=%s, got %s", key, want, got)
+		}
+	}
+}
+
+func TestUnaryError(t *testing.T) {
+	tracer, sr := newTracer()
+	invoker := serve(tracer, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	})
+	_ = tracer.UnaryClientInterceptor()(outgoing(context.Background()), "/user.User/Get", wrapperspb.String("u1"), &wrapperspb.StringValue{}, nil, invoker)
+
+	for _, span := range sr.Ended() {
+		if span.Status().Code != otelcodes.Error {
+			t.Fatalf("want the error status of the %s span, got %s", span.SpanKind(), span.Status().Code)
+		}
+		if got := attr(span, "rpc.grpc.status_code").AsInt64(); got != int64(codes.NotFound) {
+			t.Fatalf("want the NotFound code, got %d", got)
+		}
+	}
+}
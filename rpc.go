@@ -12,44 +12,72 @@ import (
 	"github.com/obnahsgnaw/application/servertype"
 	"github.com/obnahsgnaw/application/service/regCenter"
 	"github.com/obnahsgnaw/http/listener"
+	"github.com/obnahsgnaw/rpc/pkg/rpcacl"
+	"github.com/obnahsgnaw/rpc/pkg/rpcauth"
 	"github.com/obnahsgnaw/rpc/pkg/rpcclient"
+	"github.com/obnahsgnaw/rpc/pkg/rpclimit"
+	"github.com/obnahsgnaw/rpc/pkg/rpcmetrics"
 	"github.com/obnahsgnaw/rpc/pkg/rpcserver"
+	"github.com/obnahsgnaw/rpc/pkg/rpcsign"
+	"github.com/obnahsgnaw/rpc/pkg/rpctls"
+	"github.com/obnahsgnaw/rpc/pkg/rpctrace"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
 	"io"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 )
 
 // Server RPC server
 type Server struct {
-	app           *application.Application
-	id            string
-	name          string
-	endType       endtype.EndType
-	serverType    servertype.ServerType
-	server        *rpcserver.Server
-	clientManager *rpcclient.Manager
-	lsNer         *listener.PortedListener
-	logger        *zap.Logger
-	logCnf        *logger.Config
-	pServer       *PServer
-	services      []ServiceInfo
-	regInfos      map[string]*regCenter.RegInfo
-	errs          []error
-	regAble       bool
-	running       bool
-	callTtl       time.Duration
-	accessWriter  io.Writer
-	errLogger     *log.Logger
+	app            *application.Application
+	id             string
+	name           string
+	endType        endtype.EndType
+	serverType     servertype.ServerType
+	server         *rpcserver.Server
+	clientManager  *rpcclient.Manager
+	lsNer          *listener.PortedListener
+	logger         *zap.Logger
+	logCnf         *logger.Config
+	pServer        *PServer
+	services       []ServiceInfo
+	regInfos       map[string]*regCenter.RegInfo
+	errs           []error
+	regAble        bool
+	running        bool
+	callTtl        time.Duration
+	regWeight      int
+	healthCheck    *rpcclient.HealthCheckConfig
+	drainDelay     time.Duration
+	drainTimeout   time.Duration
+	errMode        rpcserver.ErrorMode
+	metricsReg     prometheus.Registerer
+	tracer         *rpctrace.Tracer
+	serverTLS      *rpctls.Config
+	sharedListener bool
+	clientTLS      *rpctls.Config
+	aclFile        string
+	acl            *rpcacl.ACL
+	limitCnf       *rpclimit.Config
+	limitFile      string
+	limiter        *rpclimit.Limiter
+	validators     []rpcauth.Validator
+	auth           *rpcauth.Authenticator
+	signer         *rpcsign.Signer
+	verifier       *rpcsign.Verifier
+	verifyWindow   time.Duration
+	verifyKeys     map[string][]rpcsign.Key
+	accessWriter   io.Writer
+	errLogger      *log.Logger
 }
 
 // ServiceInfo rpc service provider
-type ServiceInfo struct {
-	Desc grpc.ServiceDesc
-	Impl interface{}
-}
+type ServiceInfo = rpcserver.ServiceInfo
 
 func New(app *application.Application, lr *listener.PortedListener, id, name string, et endtype.EndType, ps *PServer, options ...Option) *Server {
 	s := &Server{
@@ -63,6 +91,7 @@ func New(app *application.Application, lr *listener.PortedListener, id, name str
 		pServer:       ps,
 		clientManager: rpcclient.NewManager(),
 		callTtl:       time.Second * 5,
+		drainTimeout:  time.Second * 10,
 	}
 	if s.id == "" || s.name == "" {
 		s.addErr(s.err("id or name invalid", nil))
@@ -70,26 +99,79 @@ func New(app *application.Application, lr *listener.PortedListener, id, name str
 	s.With(options...)
 	s.initLogger()
 	s.server = rpcserver.New(lr, s.logger)
+	s.server.SetErrorMode(s.errMode)
+	s.clientManager.SetHeaderResolver(s.inboundHeader)
+	if s.pServer != nil || s.sharedListener {
+		_ = s.server.ShareListener()
+	}
+	if s.serverTLS != nil {
+		if err := s.server.SetTLS(s.serverTLS); err != nil {
+			s.addErr(s.err("server tls invalid", err))
+		}
+	}
+	if s.clientTLS != nil {
+		if err := s.clientManager.SetTLS(s.clientTLS); err != nil {
+			s.addErr(s.err("client tls invalid", err))
+		}
+	}
+	if s.aclFile != "" {
+		s.initACL()
+	}
+	if s.limitCnf != nil || s.limitFile != "" {
+		s.initLimit()
+	}
+	if s.tracer != nil {
+		s.initTracing()
+	}
+	if s.metricsReg != nil {
+		s.initMetrics()
+	}
+	if s.signer != nil || s.verifyKeys != nil {
+		s.initSigning()
+	}
+	if len(s.validators) > 0 {
+		s.initAuth()
+	}
 	s.server.RegisterAfterHandler(func(ctx context.Context, head rpcserver.Header, req interface{}, info *grpc.UnaryServerInfo, resp interface{}, err error) {
 		desc := utils.ToStr("rq-id:", head.RqId, " from ", head.From, " to call ", head.To, ".", info.FullMethod)
 		if err != nil {
-			s.logger.Warn(utils.ToStr("rpc serve[", desc, "] failed, ", err.Error()), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.AppId), zap.Any("req", req), zap.Any("resp", resp))
+			s.logger.Warn(utils.ToStr("rpc serve[", desc, "] failed, ", err.Error()), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.AppId), zap.Any("rq_meta", head.Meta), zap.Any("req", req), zap.Any("resp", resp))
+		} else {
+			s.logger.Debug(utils.ToStr("rpc serve[", desc, "] success"), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.RqId), zap.Any("rq_meta", head.Meta), zap.Any("req", req), zap.Any("resp", resp))
+		}
+	})
+	s.server.RegisterStreamAfterHandler(func(ctx context.Context, head rpcserver.Header, info *grpc.StreamServerInfo, err error) {
+		desc := utils.ToStr("rq-id:", head.RqId, " from ", head.From, " to call ", head.To, ".", info.FullMethod)
+		if err != nil {
+			s.logger.Warn(utils.ToStr("rpc stream serve[", desc, "] failed, ", err.Error()), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.RqId), zap.Any("rq_meta", head.Meta))
 		} else {
-			s.logger.Debug(utils.ToStr("rpc serve[", desc, "] success"), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.RqId), zap.Any("req", req), zap.Any("resp", resp))
+			s.logger.Debug(utils.ToStr("rpc stream serve[", desc, "] success"), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.RqId), zap.Any("rq_meta", head.Meta))
 		}
 	})
 	s.clientManager.RegisterAfterHandler(func(ctx context.Context, head rpcclient.Header, method string, req, reply interface{}, cc *grpc.ClientConn, err error, opts ...grpc.CallOption) {
 		desc := utils.ToStr("rq-id:", head.RqId, " from ", head.From, " to call ", head.To, ".", method)
-		if err != nil {
+		if rpcclient.IsThrottled(err) {
+			s.logger.Debug(utils.ToStr("rpc call[", desc, "] throttled, ", err.Error()), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.RqId))
+		} else if err != nil {
 			if s.errLogger != nil {
 				s.errLogger.Printf(utils.ToStr("[ ", time.Now().Format(time.RFC3339), " ] - ", head.RqId, " ", s.name, " RPC ", head.From, " ", head.To, " ", method, " ", err.Error(), "\n"))
 			}
-			s.logger.Warn(utils.ToStr("rpc call[", desc, "] failed, ", err.Error()), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.RqId), zap.Any("req", req), zap.Any("resp", reply))
+			s.logger.Warn(utils.ToStr("rpc call[", desc, "] failed, ", err.Error()), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.RqId), zap.Any("rq_meta", head.Meta), zap.Any("req", req), zap.Any("resp", reply))
 		} else {
 			if s.accessWriter != nil {
 				_, _ = fmt.Fprint(s.accessWriter, utils.ToStr("[ ", time.Now().Format(time.RFC3339), " ] - ", head.RqId, " ", s.name, " RPC ", head.From, " ", head.To, " ", method, "\n"))
 			}
-			s.logger.Debug(utils.ToStr("rpc call[", desc, "] success"), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.To), zap.Any("req", req), zap.Any("resp", reply))
+			s.logger.Debug(utils.ToStr("rpc call[", desc, "] success"), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.To), zap.Any("rq_meta", head.Meta), zap.Any("req", req), zap.Any("resp", reply))
+		}
+	})
+	s.clientManager.RegisterStreamAfterHandler(func(ctx context.Context, head rpcclient.Header, desc *grpc.StreamDesc, method string, cc *grpc.ClientConn, err error) {
+		desc1 := utils.ToStr("rq-id:", head.RqId, " from ", head.From, " to call ", head.To, ".", method)
+		if rpcclient.IsThrottled(err) {
+			s.logger.Debug(utils.ToStr("rpc stream call[", desc1, "] throttled, ", err.Error()), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.RqId))
+		} else if err != nil {
+			s.logger.Warn(utils.ToStr("rpc stream call[", desc1, "] failed, ", err.Error()), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.RqId), zap.Any("rq_meta", head.Meta))
+		} else {
+			s.logger.Debug(utils.ToStr("rpc stream call[", desc1, "] success"), zap.String("rq_from", head.From), zap.String("rq_to", head.To), zap.String("rq_id", head.RqId), zap.Any("rq_meta", head.Meta))
 		}
 	})
 	s.AddRegInfo(id, name, s.pServer)
@@ -124,7 +206,7 @@ func (s *Server) Type() servertype.ServerType {
 	return s.serverType
 }
 
-// Release resource
+// Release resource, drain in order: deregister, health not serving, wait the propagation, stop accepting and wait the in-flight rpc
 func (s *Server) Release() {
 	if s.RegEnabled() && s.app.Register() != nil {
 		for _, info := range s.regInfos {
@@ -133,6 +215,25 @@ func (s *Server) Release() {
 			})
 		}
 	}
+	s.server.Shutdown()
+	s.logger.Debug("health status set to not serving")
+	if s.running {
+		if s.drainDelay > 0 {
+			s.logger.Debug(utils.ToStr("wait ", s.drainDelay.String(), " for the deregistration propagation"))
+			time.Sleep(s.drainDelay)
+		}
+		s.logger.Info(utils.ToStr("draining, in-flight=", strconv.FormatInt(s.server.InFlight(), 10)))
+		if s.server.Drain(s.drainTimeout, func(inFlight int64) {
+			s.logger.Info(utils.ToStr("draining, in-flight=", strconv.FormatInt(inFlight, 10)))
+		}) {
+			s.logger.Info("drained")
+		} else {
+			s.logger.Warn(utils.ToStr("drain timeout, force stopped, in-flight=", strconv.FormatInt(s.server.InFlight(), 10)))
+		}
+		if s.server.TLS() {
+			s.lsNer.Close()
+		}
+	}
 	s.clientManager.Release()
 	s.logger.Info("released")
 	_ = s.logger.Sync()
@@ -176,24 +277,44 @@ func (s *Server) Run(failedCb func(error)) {
 			failedCb(s.err("watch failed", err))
 			return
 		}
+		if s.regAble && s.healthCheck != nil {
+			s.startHealthCheck()
+		}
 	}
 	s.logger.Info("register initialized")
 	s.logger.Info("initialized")
 	s.server.SyncStart(s.id, func(err error) {
 		failedCb(s.err("run failed, err="+err.Error(), nil))
 	})
-	go func() {
-		defer func() {
-			s.lsNer.CloseWithKey(s.id)
+	// the TLS server accepts on the raw listener
+	if !s.server.TLS() {
+		go func() {
+			defer func() {
+				s.lsNer.CloseWithKey(s.id)
+			}()
+			if err := s.lsNer.ServeWithKey(s.id); err != nil {
+				failedCb(err)
+			}
 		}()
-		if err := s.lsNer.ServeWithKey(s.id); err != nil {
-			failedCb(err)
-		}
-	}()
+	}
 	s.logger.Info(utils.ToStr("server[", s.Host().String(), "] listen and serving..."))
 	s.running = true
 }
 
+// SetServing set the health status of the whole server
+func (s *Server) SetServing(serving bool) {
+	s.SetServiceServing("", serving)
+}
+
+// SetServiceServing set the health status of a registered service
+func (s *Server) SetServiceServing(service string, serving bool) {
+	st := grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	if serving {
+		st = grpc_health_v1.HealthCheckResponse_SERVING
+	}
+	s.server.SetServingStatus(service, st)
+}
+
 // Host return the server host
 func (s *Server) Host() url.Host {
 	return url.Host{
@@ -222,12 +343,19 @@ func (s *Server) AddRegInfo(id, name string, parent *PServer) {
 			EndType: s.endType.String(),
 		},
 		Host:      s.server.Listener().Host(),
-		Val:       s.server.Listener().Host(),
+		Val:       s.regVal(),
 		Ttl:       s.app.RegTtl(),
 		KeyPreGen: regCenter.DefaultRegKeyPrefixGenerator(),
 	}
 }
 
+func (s *Server) regVal() string {
+	if s.regWeight > 0 {
+		return utils.ToStr(s.server.Listener().Host(), "?weight=", strconv.Itoa(s.regWeight))
+	}
+	return s.server.Listener().Host()
+}
+
 // RegEnabled reg enabled
 func (s *Server) RegEnabled() bool {
 	return s.regAble
@@ -248,6 +376,31 @@ func (s *Server) Server() *rpcserver.Server {
 	return s.server
 }
 
+// RateLimiter return the rate limiter, nil if not enabled
+func (s *Server) RateLimiter() *rpclimit.Limiter {
+	return s.limiter
+}
+
+// Signer return the signer of the called rpc, nil if not enabled
+func (s *Server) Signer() *rpcsign.Signer {
+	return s.signer
+}
+
+// Verifier return the signature verifier of the served rpc, nil if not enabled
+func (s *Server) Verifier() *rpcsign.Verifier {
+	return s.verifier
+}
+
+// Authenticator return the authenticator, nil if not enabled
+func (s *Server) Authenticator() *rpcauth.Authenticator {
+	return s.auth
+}
+
+// AccessControl return the ACL, nil if not enabled
+func (s *Server) AccessControl() *rpcacl.ACL {
+	return s.acl
+}
+
 // Logger return the logger
 func (s *Server) Logger() *zap.Logger {
 	return s.logger
@@ -279,13 +432,106 @@ func (s *Server) watch(register regCenter.Register) (err error) {
 				s.clientManager.Rm(rpcclient.Module(module), addr)
 			} else {
 				s.logger.Debug(utils.ToStr("rpc[", module, "] added"))
-				s.clientManager.Add(rpcclient.Module(module), addr)
+				s.clientManager.AddWithVal(rpcclient.Module(module), addr, val)
 			}
 		})
 	}
 	return
 }
 
+func (s *Server) startHealthCheck() {
+	cnf := *s.healthCheck
+	onChange := cnf.OnChange
+	cnf.OnChange = func(module rpcclient.Module, addr string, healthy bool) {
+		if healthy {
+			s.logger.Info(utils.ToStr("rpc[", module.String(), "] addr[", addr, "] turned healthy"))
+		} else {
+			s.logger.Warn(utils.ToStr("rpc[", module.String(), "] addr[", addr, "] turned unhealthy"))
+		}
+		if onChange != nil {
+			onChange(module, addr, healthy)
+		}
+	}
+	s.clientManager.StartHealthCheck(s.app.Context(), &cnf)
+	s.logger.Debug("rpc health check started")
+}
+
+// initMetrics the metrics are labeled with the server id, so the servers can share a registry
+func (s *Server) initMetrics() {
+	m, err := rpcmetrics.New(prometheus.WrapRegistererWith(prometheus.Labels{"server": s.id}, s.metricsReg), "")
+	if err != nil {
+		s.addErr(s.err("metrics register failed", err))
+		return
+	}
+	if err = m.WatchManager(s.clientManager); err != nil {
+		s.addErr(s.err("metrics register failed", err))
+		return
+	}
+	s.server.RegisterUnaryInterceptor(m.UnaryServerInterceptor())
+	s.server.RegisterStreamInterceptor(m.StreamServerInterceptor())
+	s.clientManager.RegisterUnaryInterceptor(m.UnaryClientInterceptor())
+	s.clientManager.RegisterStreamInterceptor(m.StreamClientInterceptor())
+}
+
+// initACL the ACL is the first before interceptor, so the denied calls reach no other one
+func (s *Server) initACL() {
+	acl, err := rpcacl.Load(s.aclFile, 0, s.logger)
+	if err != nil {
+		s.addErr(s.err("acl load failed", err))
+		return
+	}
+	s.acl = acl
+	s.server.RegisterBeforeInterceptor(acl.BeforeInterceptor())
+	s.server.RegisterStreamBeforeInterceptor(acl.StreamBeforeInterceptor())
+}
+
+// initLimit the limiter is after the ACL, so the denied calls take no token
+func (s *Server) initLimit() {
+	var err error
+	if s.limitFile != "" {
+		s.limiter, err = rpclimit.Load(s.limitFile, 0, s.logger)
+	} else {
+		s.limiter, err = rpclimit.New(s.limitCnf, s.logger)
+	}
+	if err != nil {
+		s.addErr(s.err("rate limit init failed", err))
+		return
+	}
+	s.server.RegisterBeforeInterceptor(s.limiter.BeforeInterceptor())
+	s.server.RegisterStreamBeforeInterceptor(s.limiter.StreamBeforeInterceptor())
+}
+
+// initSigning the signatures are verified before the authentication, the caller module is trusted by then
+func (s *Server) initSigning() {
+	if s.signer != nil {
+		s.clientManager.RegisterUnaryInterceptor(s.signer.UnaryClientInterceptor())
+		s.clientManager.RegisterStreamInterceptor(s.signer.StreamClientInterceptor())
+	}
+	if s.verifyKeys != nil {
+		s.verifier = rpcsign.NewVerifier(s.verifyWindow, s.logger)
+		for from, keys := range s.verifyKeys {
+			s.verifier.SetKeys(from, keys...)
+		}
+		s.server.RegisterUnaryInterceptor(s.verifier.UnaryServerInterceptor())
+		s.server.RegisterStreamInterceptor(s.verifier.StreamServerInterceptor())
+	}
+}
+
+// initAuth the authenticator is inside the tracing and metrics, so the rejected calls are observed
+func (s *Server) initAuth() {
+	s.auth = rpcauth.New(s.logger, s.validators...)
+	s.server.RegisterUnaryInterceptor(s.auth.UnaryInterceptor())
+	s.server.RegisterStreamInterceptor(s.auth.StreamInterceptor())
+}
+
+// initTracing the tracing interceptors are the outermost, so the span covers the other interceptors
+func (s *Server) initTracing() {
+	s.server.RegisterUnaryInterceptor(s.tracer.UnaryServerInterceptor())
+	s.server.RegisterStreamInterceptor(s.tracer.StreamServerInterceptor())
+	s.clientManager.RegisterUnaryInterceptor(s.tracer.UnaryClientInterceptor())
+	s.clientManager.RegisterStreamInterceptor(s.tracer.StreamClientInterceptor())
+}
+
 func (s *Server) initLogger() {
 	var name string
 	s.logCnf = s.app.LogConfig()
@@ -0,0 +1,24 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/obnahsgnaw/http/listener"
+	"github.com/obnahsgnaw/rpc/pkg/rpcclient"
+	"github.com/obnahsgnaw/rpc/pkg/rpcserver"
+)
+
+func TestRegVal(t *testing.T) {
+	l, err := listener.Default("127.0.0.1", 17391)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	s := &Server{server: rpcserver.New(l, nil)}
+	for weight, want := range map[int]int{0: 1, 5: 5} {
+		s.regWeight = weight
+		if host, w := rpcclient.ParseRegVal(s.regVal()); host != l.Host() || w != want {
+			t.Fatalf("weight %d: want %s and %d, got %s and %d", weight, l.Host(), want, host, w)
+		}
+	}
+}